@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
 	"golang.org/x/sync/errgroup"
 	"os"
 	"os/signal"
@@ -10,6 +11,10 @@ import (
 	"overkiz-adapter/internal/domain"
 	"overkiz-adapter/internal/http"
 	"overkiz-adapter/internal/log"
+	"overkiz-adapter/internal/mqtt"
+	"overkiz-adapter/internal/rules"
+	"overkiz-adapter/internal/schedule"
+	"overkiz-adapter/internal/webhook"
 	"syscall"
 )
 
@@ -20,8 +25,19 @@ func main() {
 
 	// Parse command line parameters.
 	configFile := flag.String("config-file", "config.json", "Full path to the configuration file")
+	generateApiKey := flag.Bool("generate-api-key", false, "Generate an api key and its hash for the configuration file")
 	flag.Parse()
 
+	if *generateApiKey {
+		key, hash, err := http.GenerateApiKey()
+		if err != nil {
+			log.Fatalf("Unable to generate api key: %s", err.Error())
+			syscall.Exit(-1)
+		}
+		fmt.Printf("Key:  %s\nHash: %s\n", key, hash)
+		return
+	}
+
 	// Load configuration
 	configuration, err := config.LoadConfiguration(*configFile)
 	if err != nil {
@@ -29,20 +45,84 @@ func main() {
 		syscall.Exit(-1)
 	}
 
-	overkiz, err := domain.NewOverkiz(configuration.Token, configuration.Host, syncGroupContext)
+	scenes := newScenes(configuration.Scenes)
+	gateways := make([]*domain.Overkiz, 0, len(configuration.Gateways))
+	for _, gateway := range configuration.Gateways {
+		overkiz, err := domain.NewOverkiz(newGateway(gateway), syncGroupContext)
+		if err != nil {
+			log.Fatalf("Unable to connect to Overkiz gateway %s: %s", gateway.Name, err.Error())
+			syscall.Exit(-1)
+		}
+		err = overkiz.SetScenes(scenes)
+		if err != nil {
+			log.Fatalf("Invalid scene configuration for gateway %s: %s", gateway.Name, err.Error())
+			syscall.Exit(-1)
+		}
+		gateways = append(gateways, overkiz)
+	}
+
+	ruleEngine, err := rules.NewEngine(configuration.Rules, configuration.Location, gateways...)
+	if err != nil {
+		log.Fatalf("Invalid rule configuration: %s", err.Error())
+		syscall.Exit(-1)
+	}
+	syncGroup.Go(func() error {
+		return ruleEngine.Run(syncGroupContext)
+	})
+
+	scheduler, err := schedule.NewScheduler(configuration.Schedules, configuration.Location, gateways...)
 	if err != nil {
-		log.Fatalf("Unable to connect to Overkiz: %s", err.Error())
+		log.Fatalf("Invalid schedule configuration: %s", err.Error())
 		syscall.Exit(-1)
 	}
+	syncGroup.Go(func() error {
+		return scheduler.Run(syncGroupContext)
+	})
 
 	// Start the http server
-	httpServer, err := http.NewServer(configuration.Http, overkiz)
+	httpServer, err := http.NewServer(configuration.Http, ruleEngine, scheduler, gateways...)
 	if err != nil {
-		log.Warningf("Failed to create http server: %s", err.Error())
+		log.Fatalf("Failed to create http server: %s", err.Error())
+		syscall.Exit(-1)
 	}
 	syncGroup.Go(func() error {
 		return httpServer.Start()
 	})
+	if configuration.Http.Tls != nil {
+		// Reload the certificate on SIGHUP, for example after it was renewed.
+		hangup := make(chan os.Signal, 1)
+		signal.Notify(hangup, syscall.SIGHUP)
+		syncGroup.Go(func() error {
+			defer signal.Stop(hangup)
+			for {
+				select {
+				case <-syncGroupContext.Done():
+					return nil
+				case <-hangup:
+					err := httpServer.ReloadCertificate()
+					if err != nil {
+						log.Errorf("Failed to reload certificate: %s", err.Error())
+					}
+				}
+			}
+		})
+	}
+	if configuration.Mqtt != nil {
+		bridge := mqtt.NewBridge(configuration.Mqtt, gateways...)
+		syncGroup.Go(func() error {
+			return bridge.Run(syncGroupContext)
+		})
+	}
+	if len(configuration.Webhooks) > 0 {
+		dispatcher, err := webhook.NewDispatcher(configuration.Webhooks, gateways...)
+		if err != nil {
+			log.Fatalf("Invalid webhook configuration: %s", err.Error())
+			syscall.Exit(-1)
+		}
+		syncGroup.Go(func() error {
+			return dispatcher.Run(syncGroupContext)
+		})
+	}
 	syncGroup.Go(func() error {
 		<-syncGroupContext.Done()
 		if httpServer != nil {
@@ -58,3 +138,39 @@ func main() {
 		log.Errorf("%v", err)
 	}
 }
+
+func newGateway(gateway *config.Gateway) *domain.Gateway {
+	return &domain.Gateway{
+		Name:                   gateway.Name,
+		Token:                  gateway.Token,
+		Host:                   gateway.Host,
+		Pin:                    gateway.Pin,
+		Port:                   gateway.Port,
+		BasePath:               gateway.BasePath,
+		PollInterval:           gateway.PollInterval.Duration,
+		RequestTimeout:         gateway.RequestTimeout.Duration,
+		RetryInterval:          gateway.RetryInterval.Duration,
+		MaxRetryInterval:       gateway.MaxRetryInterval.Duration,
+		CaFile:                 gateway.CaFile,
+		CertificateFingerprint: gateway.CertificateFingerprint,
+		ServerName:             gateway.ServerName,
+		InsecureSkipVerify:     gateway.InsecureSkipVerify,
+	}
+}
+
+func newScenes(scenes []*config.Scene) []*domain.Scene {
+	result := make([]*domain.Scene, 0, len(scenes))
+	for _, scene := range scenes {
+		actions := make([]*domain.SceneAction, 0, len(scene.Actions))
+		for _, sceneAction := range scene.Actions {
+			action := domain.SceneAction(*sceneAction)
+			actions = append(actions, &action)
+		}
+		result = append(result, &domain.Scene{
+			Name:    scene.Name,
+			Gateway: scene.Gateway,
+			Actions: actions,
+		})
+	}
+	return result
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"os"
 	"overkiz-adapter/internal/domain"
+	"text/tabwriter"
+	"time"
 )
 
 func main() {
@@ -14,6 +16,7 @@ func main() {
 	pod := new(string)
 	label := new(string)
 	uuid := new(string)
+	timeout := new(time.Duration)
 
 	loginCmd := flag.NewFlagSet("login", flag.ExitOnError)
 	loginCmd.StringVar(region, "region", "", "Region, one of \"europe\", \"middle east\", \"africa\", \"asia\", \"pacific\" or \"north america\"")
@@ -40,6 +43,9 @@ func main() {
 	docCmd := flag.NewFlagSet("doc", flag.ExitOnError)
 	docCmd.StringVar(region, "region", "", "Region, one of \"europe\", \"middle east\", \"africa\", \"asia\", \"pacific\" or \"north america\"")
 
+	discoverCmd := flag.NewFlagSet("discover", flag.ExitOnError)
+	discoverCmd.DurationVar(timeout, "timeout", 5*time.Second, "The time to wait for gateways to respond")
+
 	if len(os.Args) < 2 {
 		printTokenUsage()
 		os.Exit(1)
@@ -165,6 +171,18 @@ func main() {
 			fmt.Println(err)
 			os.Exit(1)
 		}
+	case "discover":
+		err := discoverCmd.Parse(os.Args[2:])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		gateways, err := domain.DiscoverGateways(*timeout)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		printGateways(gateways)
 	default:
 		printTokenUsage()
 		os.Exit(1)
@@ -183,6 +201,20 @@ func printTokenUsage() {
 	println("  list       List all tokens")
 	println("  create     Create a new token")
 	println("  delete     Delete an existing token")
+	println("  discover   Discover gateways on the local network")
+}
+
+func printGateways(gateways []*domain.DiscoveredGateway) {
+	if len(gateways) == 0 {
+		fmt.Println("No gateways found")
+		return
+	}
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(writer, "PIN\tHost\tIP\tFirmware\tAPI version")
+	for _, gateway := range gateways {
+		_, _ = fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\n", gateway.Pin, gateway.Host, gateway.IP, gateway.Firmware, gateway.ApiVersion)
+	}
+	_ = writer.Flush()
 }
 
 func printLoginUsage() {
@@ -3,9 +3,14 @@ module overkiz-adapter
 go 1.22
 
 require (
+	github.com/eclipse/paho.mqtt.golang v1.4.3
 	github.com/go-chi/chi/v5 v5.1.0
 	github.com/go-chi/render v1.0.3
 	github.com/go-playground/validator/v10 v10.22.0
+	github.com/gorilla/websocket v1.5.0
+	github.com/hashicorp/mdns v1.0.5
+	github.com/mochi-mqtt/server/v2 v2.6.0
+	go.nhat.io/cookiejar v0.1.0
 	golang.org/x/sync v0.7.0
 )
 
@@ -16,10 +21,12 @@ require (
 	github.com/go-playground/locales v0.14.1 // indirect
 	github.com/go-playground/universal-translator v0.18.1 // indirect
 	github.com/leodido/go-urn v1.4.0 // indirect
+	github.com/miekg/dns v1.1.41 // indirect
+	github.com/rs/xid v1.4.0 // indirect
 	github.com/spf13/afero v1.9.4 // indirect
-	go.nhat.io/cookiejar v0.1.0 // indirect
 	golang.org/x/crypto v0.25.0 // indirect
 	golang.org/x/net v0.27.0 // indirect
 	golang.org/x/sys v0.22.0 // indirect
 	golang.org/x/text v0.16.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -0,0 +1,126 @@
+package actions
+
+import (
+	"errors"
+	"fmt"
+	"overkiz-adapter/internal/config"
+	"overkiz-adapter/internal/domain"
+	"overkiz-adapter/internal/log"
+	"strings"
+)
+
+// Executor executes the actions of rules and schedules on the gateways.
+type Executor struct {
+	gateways []*domain.Overkiz
+}
+
+func NewExecutor(gateways ...*domain.Overkiz) *Executor {
+	return &Executor{
+		gateways: gateways,
+	}
+}
+
+// Validate returns an error when the action refers to a device or scene that does not exist. The device can only be
+// validated when the devices of the gateways are loaded, otherwise an unknown device is reported when the action is
+// executed.
+func (e *Executor) Validate(action *config.Action) error {
+	if action.Device != "" {
+		_, device := e.findDevice(action.Gateway, action.Device)
+		if device == nil && !e.devicesLoaded(action.Gateway) {
+			log.Warningf("No devices loaded, unable to validate device %s", action.Device)
+		} else if device == nil {
+			return fmt.Errorf("unknown device %s", action.Device)
+		}
+	}
+	if action.Scene != "" && e.findScene(action.Gateway, action.Scene) == nil {
+		return fmt.Errorf("unknown scene %s", action.Scene)
+	}
+	return nil
+}
+
+// ExecuteAll executes all actions, also when some of them fail.
+func (e *Executor) ExecuteAll(actions []*config.Action) error {
+	var errs []error
+	for _, action := range actions {
+		err := e.Execute(action)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Execute executes a single action. An action on a class is executed on all gateways the action is scoped to.
+func (e *Executor) Execute(action *config.Action) error {
+	switch {
+	case action.Scene != "":
+		overkiz := e.findScene(action.Gateway, action.Scene)
+		if overkiz == nil {
+			return fmt.Errorf("unknown scene %s", action.Scene)
+		}
+		_, err := overkiz.ExecuteScene(action.Scene)
+		return err
+	case action.Device != "":
+		overkiz, device := e.findDevice(action.Gateway, action.Device)
+		if device == nil {
+			return fmt.Errorf("unknown device %s", action.Device)
+		}
+		_, err := overkiz.ExecuteDeviceCommand(device, action.Command, action.Parameters)
+		return err
+	default:
+		var errs []error
+		for _, overkiz := range e.Gateways(action.Gateway) {
+			_, err := overkiz.ExecuteCommand(action.Class, action.Command, action.Parameters)
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
+		return errors.Join(errs...)
+	}
+}
+
+// devicesLoaded returns true when the devices of all gateways with the given name, or of all gateways when no name is
+// given, were loaded at least once.
+func (e *Executor) devicesLoaded(gateway string) bool {
+	for _, overkiz := range e.Gateways(gateway) {
+		if lastRefresh, _ := overkiz.RefreshStatus(); lastRefresh.IsZero() {
+			return false
+		}
+	}
+	return true
+}
+
+// findDevice returns the device with the given label or device url together with its gateway.
+func (e *Executor) findDevice(gateway string, identifier string) (*domain.Overkiz, *domain.Device) {
+	for _, overkiz := range e.Gateways(gateway) {
+		if device := overkiz.Device(identifier); device != nil {
+			return overkiz, device
+		}
+	}
+	return nil, nil
+}
+
+// findScene returns the gateway that has the scene with the given name.
+func (e *Executor) findScene(gateway string, name string) *domain.Overkiz {
+	for _, overkiz := range e.Gateways(gateway) {
+		for _, scene := range overkiz.Scenes() {
+			if strings.EqualFold(scene.Name, name) {
+				return overkiz
+			}
+		}
+	}
+	return nil
+}
+
+// Gateways returns the gateway with the given name, or all gateways when no name is given.
+func (e *Executor) Gateways(name string) []*domain.Overkiz {
+	if name == "" {
+		return e.gateways
+	}
+	for _, overkiz := range e.gateways {
+		if overkiz.Name() == name {
+			return []*domain.Overkiz{overkiz}
+		}
+	}
+	return nil
+}
@@ -1,15 +1,46 @@
 package config
 
 import (
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	gpv "github.com/go-playground/validator/v10"
 	"os"
+	"overkiz-adapter/internal/domain"
+	"strings"
+	"time"
 )
 
 type Configuration struct {
-	Token string `json:"token" validate:"required"`
-	Host  string `json:"host"`
-	Http  *Http  `json:"http" validate:"required"`
+	Token     string      `json:"token" validate:"required_without=Gateways"`
+	Host      string      `json:"host"`
+	Gateway   *Gateway    `json:"gateway"`
+	Gateways  []*Gateway  `json:"gateways" validate:"dive"`
+	Http      *Http       `json:"http" validate:"required"`
+	Mqtt      *Mqtt       `json:"mqtt"`
+	Scenes    []*Scene    `json:"scenes" validate:"dive"`
+	Webhooks  []*Webhook  `json:"webhooks" validate:"dive"`
+	Location  *Location   `json:"location"`
+	Rules     []*Rule     `json:"rules" validate:"dive"`
+	Schedules []*Schedule `json:"schedules" validate:"dive"`
+}
+
+type Gateway struct {
+	Name                   string   `json:"name"`
+	Token                  string   `json:"token"`
+	Host                   string   `json:"host"`
+	Pin                    string   `json:"pin"`
+	Port                   uint16   `json:"port"`
+	BasePath               string   `json:"base_path"`
+	PollInterval           Duration `json:"poll_interval"`
+	RequestTimeout         Duration `json:"request_timeout"`
+	RetryInterval          Duration `json:"retry_interval"`
+	MaxRetryInterval       Duration `json:"max_retry_interval"`
+	CaFile                 string   `json:"ca_file"`
+	CertificateFingerprint string   `json:"certificate_fingerprint"`
+	ServerName             string   `json:"server_name"`
+	InsecureSkipVerify     bool     `json:"insecure_skip_verify"`
 }
 
 type Http struct {
@@ -18,6 +49,153 @@ type Http struct {
 	ContextRoot  string   `json:"context_root"`
 	AllowedHosts []string `json:"allowed_hosts"`
 	BehindProxy  bool     `json:"behind_proxy"`
+	// TrustedProxies are the addresses of the proxies whose forwarded headers are honoured.
+	TrustedProxies []string `json:"trusted_proxies"`
+	DeniedHosts    []string `json:"denied_hosts"`
+	// DnsCacheTtl is how long the reverse DNS names of clients are cached for host names in the allowed and denied hosts.
+	DnsCacheTtl Duration `json:"dns_cache_ttl"`
+	// DnsNegativeTtl is how long failed lookups and clients without reverse DNS names are cached.
+	DnsNegativeTtl Duration `json:"dns_negative_ttl"`
+	DnsTimeout     Duration `json:"dns_timeout"`
+	// AllowGetActions allows actions to be executed with GET requests, for webhook senders that cannot send a POST.
+	AllowGetActions bool      `json:"allow_get_actions"`
+	ApiKeys         []*ApiKey `json:"api_keys" validate:"dive"`
+	Tls             *Tls      `json:"tls"`
+}
+
+// Tls enables https for the api. The certificate and client CAs are reloaded from their files when the application
+// receives a SIGHUP.
+type Tls struct {
+	CertFile string `json:"cert_file" validate:"required"`
+	KeyFile  string `json:"key_file" validate:"required"`
+	// SelfSigned generates a self-signed certificate in the certificate and key files when they do not exist yet.
+	SelfSigned bool   `json:"self_signed"`
+	MinVersion string `json:"min_version" validate:"omitempty,oneof=1.2 1.3"`
+	// ClientCaFile is a bundle of CA certificates. When set, clients must present a certificate signed by one of them,
+	// unless api keys are configured.
+	ClientCaFile string `json:"client_ca_file"`
+	// ClientCertificates are the client certificates that are allowed to access the api, with their scopes.
+	ClientCertificates []*ClientCertificate `json:"client_certificates" validate:"dive"`
+}
+
+// ClientCertificate grants access to the api with the scopes of the certificate. A certificate is identified by its
+// subject, which must be signed by the client CA, or by the SHA-256 fingerprint of the certificate itself.
+type ClientCertificate struct {
+	Name        string   `json:"name" validate:"required"`
+	Subject     string   `json:"subject" validate:"required_without=Fingerprint"`
+	Fingerprint string   `json:"fingerprint" validate:"required_without=Subject"`
+	Scopes      []string `json:"scopes" validate:"required,min=1,dive,oneof=read execute manage"`
+}
+
+// ClientCertificates returns the client certificates that are allowed to access the api, or nil without https.
+func (h *Http) ClientCertificates() []*ClientCertificate {
+	if h.Tls == nil {
+		return nil
+	}
+	return h.Tls.ClientCertificates
+}
+
+// ApiKey grants access to the api with the scopes of the key. Only the hex encoded SHA-256 hash of the key is stored.
+type ApiKey struct {
+	Name   string   `json:"name" validate:"required"`
+	Hash   string   `json:"hash" validate:"required,len=64,hexadecimal"`
+	Scopes []string `json:"scopes" validate:"required,min=1,dive,oneof=read execute manage"`
+}
+
+type Mqtt struct {
+	Broker        string         `json:"broker" validate:"required"`
+	ClientId      string         `json:"client_id"`
+	Username      string         `json:"username"`
+	Password      string         `json:"password"`
+	TopicPrefix   string         `json:"topic_prefix"`
+	HomeAssistant *HomeAssistant `json:"home_assistant"`
+}
+
+type HomeAssistant struct {
+	DiscoveryPrefix string `json:"discovery_prefix"`
+}
+
+type Scene struct {
+	Name    string         `json:"name" validate:"required"`
+	Gateway string         `json:"gateway"`
+	Actions []*SceneAction `json:"actions" validate:"required,min=1,dive"`
+}
+
+type SceneAction struct {
+	Device     string `json:"device" validate:"required"`
+	Command    string `json:"command" validate:"required"`
+	Parameters []any  `json:"parameters"`
+}
+
+type Webhook struct {
+	Name          string            `json:"name" validate:"required"`
+	Url           string            `json:"url" validate:"required,url"`
+	Gateway       string            `json:"gateway"`
+	Trigger       *WebhookTrigger   `json:"trigger" validate:"required"`
+	Body          string            `json:"body"`
+	Headers       map[string]string `json:"headers"`
+	Secret        string            `json:"secret"`
+	Timeout       Duration          `json:"timeout"`
+	Retries       *int              `json:"retries" validate:"omitempty,min=0"`
+	RetryInterval Duration          `json:"retry_interval"`
+}
+
+// WebhookTrigger defines when a webhook is called: when a device state starts to match the condition, or when an
+// execution on a matching device ends in the given state.
+type WebhookTrigger struct {
+	domain.StateCondition
+	Execution string `json:"execution" validate:"omitempty,oneof=COMPLETED FAILED"`
+}
+
+// Location is used to calculate the astronomical events, like sunrise and sunset, and the local times of schedules.
+type Location struct {
+	Latitude  float64 `json:"latitude" validate:"min=-90,max=90"`
+	Longitude float64 `json:"longitude" validate:"min=-180,max=180"`
+	Timezone  string  `json:"timezone"`
+}
+
+type Rule struct {
+	Name       string           `json:"name" validate:"required"`
+	Disabled   bool             `json:"disabled"`
+	Trigger    *RuleTrigger     `json:"trigger" validate:"required"`
+	Conditions []*RuleCondition `json:"conditions" validate:"dive"`
+	Actions    []*Action        `json:"actions" validate:"required,min=1,dive"`
+}
+
+// RuleTrigger defines when a rule is triggered: when a device starts to match the state condition, when the incoming
+// webhook with the given name is called, or at the times of a schedule.
+type RuleTrigger struct {
+	Gateway string `json:"gateway,omitempty"`
+	domain.StateCondition
+	Webhook  string `json:"webhook,omitempty"`
+	Schedule string `json:"schedule,omitempty"`
+}
+
+// RuleCondition must be met for the actions of a triggered rule to be executed. A device matching the state condition
+// must exist, and the current time must be after and/or before the given times of the day.
+type RuleCondition struct {
+	Gateway string `json:"gateway,omitempty"`
+	domain.StateCondition
+	After  string `json:"after,omitempty"`
+	Before string `json:"before,omitempty"`
+}
+
+// Schedule executes its actions at the times of a daily time, cron expression or astronomical event.
+type Schedule struct {
+	Name    string    `json:"name" validate:"required"`
+	At      string    `json:"at" validate:"required"`
+	Paused  bool      `json:"paused"`
+	Actions []*Action `json:"actions" validate:"required,min=1,dive"`
+}
+
+// Action executes a command on a device, on all devices of a class, or executes a scene.
+type Action struct {
+	Gateway    string `json:"gateway,omitempty"`
+	Device     string `json:"device,omitempty"`
+	Class      string `json:"class,omitempty"`
+	Scene      string `json:"scene,omitempty"`
+	Command    string `json:"command,omitempty"`
+	Parameters []any  `json:"parameters,omitempty"`
 }
 
 func LoadConfiguration(configFile string) (*Configuration, error) {
@@ -40,5 +218,244 @@ func LoadConfiguration(configFile string) (*Configuration, error) {
 	if err != nil {
 		return nil, err
 	}
+	err = setGateways(configuration)
+	if err != nil {
+		return nil, err
+	}
+	setHttpDefaults(configuration.Http)
+	err = validateTls(configuration.Http.Tls)
+	if err != nil {
+		return nil, err
+	}
+	setMqttDefaults(configuration.Mqtt)
+	err = setWebhooks(configuration)
+	if err != nil {
+		return nil, err
+	}
+	err = validateRules(configuration)
+	if err != nil {
+		return nil, err
+	}
+	err = validateSchedules(configuration)
+	if err != nil {
+		return nil, err
+	}
 	return configuration, nil
 }
+
+// setWebhooks validates the configured webhooks and applies the default settings to them.
+func setWebhooks(configuration *Configuration) error {
+	for _, webhook := range configuration.Webhooks {
+		if webhook.Gateway != "" && !hasGateway(configuration, webhook.Gateway) {
+			return fmt.Errorf("webhook %s refers to unknown gateway %s", webhook.Name, webhook.Gateway)
+		}
+		if webhook.Trigger.State == "" && webhook.Trigger.Execution == "" {
+			return fmt.Errorf("webhook %s has no state or execution trigger", webhook.Name)
+		}
+		if webhook.Timeout.Duration <= 0 {
+			webhook.Timeout.Duration = time.Second * 10
+		}
+		if webhook.Retries == nil {
+			retries := 3
+			webhook.Retries = &retries
+		}
+		if webhook.RetryInterval.Duration <= 0 {
+			webhook.RetryInterval.Duration = time.Second * 5
+		}
+	}
+	return nil
+}
+
+// validateRules checks that every rule has a unique name, a single kind of trigger and actions that refer to a single
+// target. Whether the devices and scenes exist is checked when the rules are loaded.
+func validateRules(configuration *Configuration) error {
+	names := make(map[string]struct{}, len(configuration.Rules))
+	for _, rule := range configuration.Rules {
+		if _, ok := names[rule.Name]; ok {
+			return fmt.Errorf("rule %s is defined more than once", rule.Name)
+		}
+		names[rule.Name] = struct{}{}
+		trigger := rule.Trigger
+		triggers := 0
+		for _, set := range []bool{trigger.Device != "" || trigger.Class != "", trigger.Webhook != "", trigger.Schedule != ""} {
+			if set {
+				triggers++
+			}
+		}
+		if triggers != 1 {
+			return fmt.Errorf("rule %s must have either a device, class, webhook or schedule trigger", rule.Name)
+		}
+		gateways := []string{trigger.Gateway}
+		for _, condition := range rule.Conditions {
+			gateways = append(gateways, condition.Gateway)
+		}
+		for ix, action := range rule.Actions {
+			err := validateAction(action)
+			if err != nil {
+				return fmt.Errorf("action %d of rule %s %w", ix, rule.Name, err)
+			}
+			gateways = append(gateways, action.Gateway)
+		}
+		for _, gateway := range gateways {
+			if gateway != "" && !hasGateway(configuration, gateway) {
+				return fmt.Errorf("rule %s refers to unknown gateway %s", rule.Name, gateway)
+			}
+		}
+	}
+	return nil
+}
+
+// validateSchedules checks that every schedule has a unique name and actions that refer to a single target.
+func validateSchedules(configuration *Configuration) error {
+	names := make(map[string]struct{}, len(configuration.Schedules))
+	for _, schedule := range configuration.Schedules {
+		if _, ok := names[schedule.Name]; ok {
+			return fmt.Errorf("schedule %s is defined more than once", schedule.Name)
+		}
+		names[schedule.Name] = struct{}{}
+		for ix, action := range schedule.Actions {
+			err := validateAction(action)
+			if err != nil {
+				return fmt.Errorf("action %d of schedule %s %w", ix, schedule.Name, err)
+			}
+			if action.Gateway != "" && !hasGateway(configuration, action.Gateway) {
+				return fmt.Errorf("schedule %s refers to unknown gateway %s", schedule.Name, action.Gateway)
+			}
+		}
+	}
+	return nil
+}
+
+func validateAction(action *Action) error {
+	targets := 0
+	for _, target := range []string{action.Device, action.Class, action.Scene} {
+		if target != "" {
+			targets++
+		}
+	}
+	if targets != 1 {
+		return errors.New("must have either a device, class or scene")
+	}
+	if action.Scene == "" && action.Command == "" {
+		return errors.New("has no command")
+	}
+	return nil
+}
+
+func hasGateway(configuration *Configuration, name string) bool {
+	for _, gateway := range configuration.Gateways {
+		if gateway.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func setHttpDefaults(http *Http) {
+	if http.DnsCacheTtl.Duration <= 0 {
+		http.DnsCacheTtl.Duration = time.Minute * 10
+	}
+	if http.DnsNegativeTtl.Duration <= 0 {
+		http.DnsNegativeTtl.Duration = time.Minute
+	}
+	if http.DnsTimeout.Duration <= 0 {
+		http.DnsTimeout.Duration = time.Millisecond * 500
+	}
+}
+
+// validateTls validates the client certificates and normalizes their fingerprints to lowercase hex without colons.
+// Subjects can only be trusted when they are signed by the client CA.
+func validateTls(tls *Tls) error {
+	if tls == nil {
+		return nil
+	}
+	for _, certificate := range tls.ClientCertificates {
+		if certificate.Subject != "" && tls.ClientCaFile == "" {
+			return fmt.Errorf("client certificate %s: a subject requires a client_ca_file", certificate.Name)
+		}
+		if certificate.Fingerprint != "" {
+			fingerprint := strings.ToLower(strings.ReplaceAll(certificate.Fingerprint, ":", ""))
+			if _, err := hex.DecodeString(fingerprint); err != nil || len(fingerprint) != 64 {
+				return fmt.Errorf("client certificate %s: invalid SHA-256 fingerprint %s", certificate.Name, certificate.Fingerprint)
+			}
+			certificate.Fingerprint = fingerprint
+		}
+	}
+	return nil
+}
+
+func setMqttDefaults(mqtt *Mqtt) {
+	if mqtt == nil {
+		return
+	}
+	if mqtt.ClientId == "" {
+		mqtt.ClientId = "overkiz-adapter"
+	}
+	if mqtt.TopicPrefix == "" {
+		mqtt.TopicPrefix = "overkiz"
+	}
+	mqtt.TopicPrefix = strings.TrimSuffix(mqtt.TopicPrefix, "/")
+	if mqtt.HomeAssistant != nil && mqtt.HomeAssistant.DiscoveryPrefix == "" {
+		mqtt.HomeAssistant.DiscoveryPrefix = "homeassistant"
+	}
+}
+
+// setGateways validates the configured gateways and applies the default settings to them. When the configuration
+// contains a single token and host instead of a list of gateways, a gateway named "default" is created for them.
+func setGateways(configuration *Configuration) error {
+	if len(configuration.Gateways) == 0 {
+		gateway := configuration.Gateway
+		if gateway == nil {
+			gateway = &Gateway{}
+		}
+		gateway.Name = "default"
+		gateway.Token = configuration.Token
+		gateway.Host = configuration.Host
+		configuration.Gateways = []*Gateway{gateway}
+	} else if configuration.Token != "" || configuration.Host != "" || configuration.Gateway != nil {
+		return errors.New("token, host and gateway cannot be combined with gateways")
+	}
+	names := make(map[string]struct{}, len(configuration.Gateways))
+	for ix, gateway := range configuration.Gateways {
+		if gateway.Name == "" {
+			return fmt.Errorf("gateway %d has no name", ix)
+		}
+		if gateway.Token == "" {
+			return fmt.Errorf("gateway %s has no token", gateway.Name)
+		}
+		if _, ok := names[gateway.Name]; ok {
+			return fmt.Errorf("gateway %s is defined more than once", gateway.Name)
+		}
+		names[gateway.Name] = struct{}{}
+		setGatewayDefaults(gateway)
+	}
+	for _, scene := range configuration.Scenes {
+		if scene.Gateway == "" {
+			scene.Gateway = configuration.Gateways[0].Name
+		} else if _, ok := names[scene.Gateway]; !ok {
+			return fmt.Errorf("scene %s refers to unknown gateway %s", scene.Name, scene.Gateway)
+		}
+	}
+	return nil
+}
+
+func setGatewayDefaults(gateway *Gateway) {
+	if gateway.Port == 0 {
+		gateway.Port = 8443
+	}
+	if gateway.BasePath == "" {
+		gateway.BasePath = "/enduser-mobile-web/1/enduserAPI"
+	}
+	if gateway.PollInterval.Duration <= 0 {
+		gateway.PollInterval.Duration = time.Minute * 5
+	}
+	if gateway.RequestTimeout.Duration <= 0 {
+		gateway.RequestTimeout.Duration = time.Second * 30
+	}
+	if gateway.RetryInterval.Duration <= 0 {
+		gateway.RetryInterval.Duration = time.Second * 10
+	}
+	if gateway.MaxRetryInterval.Duration <= 0 {
+		gateway.MaxRetryInterval.Duration = gateway.PollInterval.Duration
+	}
+}
@@ -0,0 +1,68 @@
+package domain
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ActionGroup is a scenario that is defined by the user in the Overkiz app.
+type ActionGroup struct {
+	Oid        string   `json:"oid"`
+	Label      string   `json:"label"`
+	Gateway    string   `json:"gateway"`
+	DeviceURLs []string `json:"device_urls"`
+}
+
+// ActionGroups retrieves the action groups that are defined at the gateway.
+func (o *Overkiz) ActionGroups() ([]*ActionGroup, error) {
+	var responseBody []*actionGroupResponse
+	err := o.doRequest("GET", "/actionGroups", nil, &responseBody)
+	if err != nil {
+		return nil, err
+	}
+	actionGroups := make([]*ActionGroup, 0, len(responseBody))
+	for _, ag := range responseBody {
+		actionGroup := &ActionGroup{
+			Oid:        ag.Oid,
+			Label:      ag.Label,
+			Gateway:    o.Name(),
+			DeviceURLs: make([]string, 0, len(ag.Actions)),
+		}
+		for _, ac := range ag.Actions {
+			actionGroup.DeviceURLs = append(actionGroup.DeviceURLs, ac.DeviceURL)
+		}
+		actionGroups = append(actionGroups, actionGroup)
+	}
+	return actionGroups, nil
+}
+
+// ExecuteActionGroup executes the action group with the given oid or label. Labels are matched case-insensitive. When
+// no such action group exists nil is returned.
+func (o *Overkiz) ExecuteActionGroup(identifier string) (*Execution, error) {
+	actionGroups, err := o.ActionGroups()
+	if err != nil {
+		return nil, err
+	}
+	var actionGroup *ActionGroup
+	for _, ag := range actionGroups {
+		if ag.Oid == identifier {
+			actionGroup = ag
+			break
+		}
+		if actionGroup == nil && strings.EqualFold(ag.Label, identifier) {
+			actionGroup = ag
+		}
+	}
+	if actionGroup == nil {
+		return nil, nil
+	}
+	var responseBody map[string]string
+	err = o.doRequest("POST", fmt.Sprintf("/exec/%s", actionGroup.Oid), nil, &responseBody)
+	if err != nil {
+		return nil, err
+	}
+	if responseBody["execId"] == "" {
+		return nil, fmt.Errorf("no execution id returned")
+	}
+	return o.executions.start(responseBody["execId"], actionGroup.Label, actionGroup.DeviceURLs), nil
+}
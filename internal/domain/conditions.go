@@ -0,0 +1,105 @@
+package domain
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// StateCondition matches the devices with the given label or device url and/or class. When a state is given, the
+// device must have that state with the given value, or a numeric value above and/or below the given limits.
+type StateCondition struct {
+	Device string   `json:"device,omitempty"`
+	Class  string   `json:"class,omitempty"`
+	State  string   `json:"state,omitempty"`
+	Value  any      `json:"value,omitempty"`
+	Above  *float64 `json:"above,omitempty"`
+	Below  *float64 `json:"below,omitempty"`
+}
+
+// ConditionTracker tracks per device whether it matches a state condition, to detect the devices that start to match.
+type ConditionTracker struct {
+	condition *StateCondition
+	lock      sync.Mutex
+	matched   map[string]bool
+}
+
+func NewConditionTracker(condition *StateCondition) *ConditionTracker {
+	return &ConditionTracker{
+		condition: condition,
+		matched:   make(map[string]bool),
+	}
+}
+
+// Update records whether the device of the given gateway matches the condition, and returns true when the device did
+// not match before. Without a state in the condition every update of a matching device returns true.
+func (t *ConditionTracker) Update(overkiz *Overkiz, device *Device) bool {
+	if !MatchesDevice(t.condition, device) {
+		return false
+	}
+	if t.condition.State == "" {
+		return true
+	}
+	matched := MatchesCondition(t.condition, device)
+	key := overkiz.Name() + "/" + device.DeviceURL
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	previous := t.matched[key]
+	t.matched[key] = matched
+	return matched && !previous
+}
+
+// MatchesDevice returns true when the device has the label or device url and the class of the condition. An empty
+// device or class in the condition matches all devices.
+func MatchesDevice(condition *StateCondition, device *Device) bool {
+	if condition.Device != "" && device.DeviceURL != condition.Device && !strings.EqualFold(device.Label, condition.Device) {
+		return false
+	}
+	return condition.Class == "" || device.Class == condition.Class
+}
+
+// MatchesCondition returns true when the device matches the condition, including the value of the state of the
+// condition.
+func MatchesCondition(condition *StateCondition, device *Device) bool {
+	if !MatchesDevice(condition, device) {
+		return false
+	}
+	if condition.State == "" {
+		return true
+	}
+	for _, state := range device.States {
+		if state.Name == condition.State {
+			return matchesValue(condition, state.Value)
+		}
+	}
+	return false
+}
+
+func matchesValue(condition *StateCondition, value any) bool {
+	number, isNumber := toFloat(value)
+	if condition.Above != nil && (!isNumber || number <= *condition.Above) {
+		return false
+	}
+	if condition.Below != nil && (!isNumber || number >= *condition.Below) {
+		return false
+	}
+	if condition.Value == nil {
+		return true
+	}
+	if expected, ok := toFloat(condition.Value); ok && isNumber {
+		return number == expected
+	}
+	return fmt.Sprint(condition.Value) == fmt.Sprint(value)
+}
+
+func toFloat(value any) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
@@ -0,0 +1,123 @@
+package domain
+
+import (
+	"fmt"
+	"github.com/hashicorp/mdns"
+	"overkiz-adapter/internal/log"
+	"strings"
+	"time"
+)
+
+const (
+	kizboxService    = "_kizbox._tcp"
+	discoveryTimeout = time.Second * 3
+)
+
+// DiscoveredGateway is a Kizbox gateway that announces itself on the local network.
+type DiscoveredGateway struct {
+	Pin        string `json:"pin"`
+	Host       string `json:"host"`
+	IP         string `json:"ip"`
+	Port       int    `json:"port"`
+	Firmware   string `json:"firmware"`
+	ApiVersion string `json:"api_version"`
+}
+
+// DiscoverGateways browses the local network for Kizbox gateways during the given timeout.
+func DiscoverGateways(timeout time.Duration) ([]*DiscoveredGateway, error) {
+	// The same entry can be sent more than once while the query completes its fields, so the entries are only read
+	// after the query returned.
+	entries := make(chan *mdns.ServiceEntry, 16)
+	done := make(chan []*mdns.ServiceEntry)
+	go func() {
+		received := make([]*mdns.ServiceEntry, 0)
+		for entry := range entries {
+			received = append(received, entry)
+		}
+		done <- received
+	}()
+	params := mdns.DefaultParams(kizboxService)
+	params.Entries = entries
+	params.Timeout = timeout
+	err := mdns.Query(params)
+	close(entries)
+	received := <-done
+	if err != nil {
+		return nil, err
+	}
+	gateways := make([]*DiscoveredGateway, 0)
+	indexes := make(map[string]int)
+	for _, entry := range received {
+		if !strings.Contains(entry.Name, kizboxService) {
+			continue
+		}
+		// The last entry with a name is the most complete one.
+		if index, ok := indexes[entry.Name]; ok {
+			gateways[index] = newDiscoveredGateway(entry)
+			continue
+		}
+		indexes[entry.Name] = len(gateways)
+		gateways = append(gateways, newDiscoveredGateway(entry))
+	}
+	return gateways, nil
+}
+
+func newDiscoveredGateway(entry *mdns.ServiceEntry) *DiscoveredGateway {
+	gateway := &DiscoveredGateway{
+		Host: strings.TrimSuffix(entry.Host, "."),
+		Port: entry.Port,
+	}
+	if entry.AddrV4 != nil {
+		gateway.IP = entry.AddrV4.String()
+	} else if entry.AddrV6 != nil {
+		gateway.IP = entry.AddrV6.String()
+	}
+	for _, field := range entry.InfoFields {
+		key, value, _ := strings.Cut(field, "=")
+		switch key {
+		case "gateway_pin":
+			gateway.Pin = value
+		case "fw_version":
+			gateway.Firmware = value
+		case "api_version":
+			gateway.ApiVersion = value
+		}
+	}
+	return gateway
+}
+
+// discoverHost looks up the gateway with the configured pin on the local network. When no pin is configured exactly
+// one gateway must be found. The ip address of the gateway is returned, together with the hostname that is expected in
+// the certificate of the gateway.
+func discoverHost(gateway *Gateway) (string, string, error) {
+	log.Infof("Discovering gateway %s on the local network", gateway.Name)
+	discovered, err := DiscoverGateways(discoveryTimeout)
+	if err != nil {
+		return "", "", err
+	}
+	match, err := matchGateway(gateway, discovered)
+	if err != nil {
+		return "", "", err
+	}
+	log.Infof("Discovered gateway %s with pin %s at %s", gateway.Name, match.Pin, match.IP)
+	return match.IP, fmt.Sprintf("gateway-%s.local", match.Pin), nil
+}
+
+// matchGateway returns the discovered gateway with the pin of the gateway, or the only discovered gateway when the
+// gateway has no pin.
+func matchGateway(gateway *Gateway, discovered []*DiscoveredGateway) (*DiscoveredGateway, error) {
+	var match *DiscoveredGateway
+	for _, candidate := range discovered {
+		if gateway.Pin != "" && candidate.Pin != gateway.Pin {
+			continue
+		}
+		if match != nil {
+			return nil, fmt.Errorf("multiple gateways found, configure the pin of gateway %s", gateway.Name)
+		}
+		match = candidate
+	}
+	if match == nil || match.IP == "" {
+		return nil, fmt.Errorf("gateway %s not found on the local network", gateway.Name)
+	}
+	return match, nil
+}
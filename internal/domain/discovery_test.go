@@ -0,0 +1,79 @@
+package domain
+
+import (
+	"github.com/hashicorp/mdns"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDiscoverGateways(t *testing.T) {
+	service, err := mdns.NewMDNSService("gateway-1234-5678-9012", kizboxService, "", "gateway-1234-5678-9012.local.", 8443,
+		[]net.IP{net.ParseIP("127.0.0.1")}, []string{"gateway_pin=1234-5678-9012", "api_version=1", "fw_version=2025.4.4-9"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := mdns.NewServer(&mdns.Config{Zone: service})
+	if err != nil {
+		t.Skipf("Unable to start mDNS responder: %v", err)
+	}
+	defer func(server *mdns.Server) {
+		_ = server.Shutdown()
+	}(server)
+
+	gateways, err := DiscoverGateways(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gateways) != 1 {
+		t.Fatalf("Expected 1 gateway, found %d", len(gateways))
+	}
+	gateway := gateways[0]
+	if gateway.Pin != "1234-5678-9012" {
+		t.Errorf("Unexpected pin %s", gateway.Pin)
+	}
+	if gateway.Host != "gateway-1234-5678-9012.local" {
+		t.Errorf("Unexpected host %s", gateway.Host)
+	}
+	if gateway.IP != "127.0.0.1" {
+		t.Errorf("Unexpected ip %s", gateway.IP)
+	}
+	if gateway.Port != 8443 {
+		t.Errorf("Unexpected port %d", gateway.Port)
+	}
+	if gateway.Firmware != "2025.4.4-9" {
+		t.Errorf("Unexpected firmware %s", gateway.Firmware)
+	}
+	if gateway.ApiVersion != "1" {
+		t.Errorf("Unexpected api version %s", gateway.ApiVersion)
+	}
+}
+
+func TestMatchGateway(t *testing.T) {
+	house := &DiscoveredGateway{Pin: "1234-5678-9012", IP: "192.168.1.20"}
+	garage := &DiscoveredGateway{Pin: "2109-8765-4321", IP: "192.168.1.30"}
+	tests := []struct {
+		name       string
+		pin        string
+		discovered []*DiscoveredGateway
+		expected   *DiscoveredGateway
+		err        string
+	}{
+		{"by pin", "2109-8765-4321", []*DiscoveredGateway{house, garage}, garage, ""},
+		{"single gateway", "", []*DiscoveredGateway{house}, house, ""},
+		{"multiple gateways without pin", "", []*DiscoveredGateway{house, garage}, nil, "multiple gateways found"},
+		{"unknown pin", "0000-0000-0000", []*DiscoveredGateway{house, garage}, nil, "not found"},
+		{"no gateways", "", nil, nil, "not found"},
+		{"without ip address", "", []*DiscoveredGateway{{Pin: "1234-5678-9012"}}, nil, "not found"},
+	}
+	for _, test := range tests {
+		match, err := matchGateway(&Gateway{Name: "house", Pin: test.pin}, test.discovered)
+		if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%s: expected an error containing %q, got %v", test.name, test.err, err)
+		}
+		if test.err == "" && (err != nil || match != test.expected) {
+			t.Errorf("%s: expected gateway %v, got %v %v", test.name, test.expected, match, err)
+		}
+	}
+}
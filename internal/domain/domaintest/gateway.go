@@ -0,0 +1,121 @@
+// Package domaintest provides a fake gateway for the tests of the packages that use the domain.
+package domaintest
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"overkiz-adapter/internal/domain"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Gateway serves the local api of a gateway over https.
+type Gateway struct {
+	// Commands receives the bodies of the action requests that are sent to the gateway.
+	Commands chan string
+	// ActionGroupExecutions receives the oids of the action groups that are executed.
+	ActionGroupExecutions chan string
+	// CancelledExecutions receives the ids of the executions that are cancelled.
+	CancelledExecutions chan string
+	// Cancellations counts the requests to cancel all executions.
+	Cancellations atomic.Int32
+	server        *httptest.Server
+	devices       string
+	actionGroups  atomic.Pointer[string]
+	states        sync.Map
+	events        []string
+	fetches       atomic.Int32
+}
+
+// NewGateway starts a gateway that serves the given json devices, it is stopped when the test ends. The consecutive
+// fetches of the events return the given json events, afterward no events are returned.
+func NewGateway(t *testing.T, devices string, events ...string) *Gateway {
+	g := &Gateway{
+		Commands:              make(chan string, 10),
+		ActionGroupExecutions: make(chan string, 10),
+		CancelledExecutions:   make(chan string, 10),
+		devices:               devices,
+		events:                events,
+	}
+	g.SetActionGroups(`[]`)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/setup/devices", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, g.devices)
+	})
+	mux.HandleFunc("/setup/devices/{deviceURL}/states", func(w http.ResponseWriter, r *http.Request) {
+		states, ok := g.states.Load(r.PathValue("deviceURL"))
+		if !ok {
+			states = `[]`
+		}
+		_, _ = fmt.Fprint(w, states)
+	})
+	mux.HandleFunc("/exec/apply", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		g.Commands <- string(body)
+		_, _ = fmt.Fprint(w, `{"execId":"1"}`)
+	})
+	mux.HandleFunc("/exec/{oid}", func(w http.ResponseWriter, r *http.Request) {
+		g.ActionGroupExecutions <- r.PathValue("oid")
+		_, _ = fmt.Fprint(w, `{"execId":"2"}`)
+	})
+	mux.HandleFunc("/actionGroups", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, *g.actionGroups.Load())
+	})
+	mux.HandleFunc("/exec/current/setup", func(w http.ResponseWriter, r *http.Request) {
+		g.Cancellations.Add(1)
+	})
+	mux.HandleFunc("DELETE /exec/current/setup/{execId}", func(w http.ResponseWriter, r *http.Request) {
+		g.CancelledExecutions <- r.PathValue("execId")
+	})
+	// The gateway only knows about running executions, and none are running.
+	mux.HandleFunc("/exec/current/{execId}", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, `{}`)
+	})
+	mux.HandleFunc("/events/register", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, `{"id":"1"}`)
+	})
+	mux.HandleFunc("/events/1/fetch", func(w http.ResponseWriter, r *http.Request) {
+		fetch := int(g.fetches.Add(1))
+		if fetch <= len(g.events) {
+			_, _ = fmt.Fprint(w, g.events[fetch-1])
+			return
+		}
+		_, _ = fmt.Fprint(w, `[]`)
+	})
+	g.server = httptest.NewTLSServer(mux)
+	t.Cleanup(g.server.Close)
+	return g
+}
+
+// SetActionGroups sets the json action groups that are served by the gateway.
+func (g *Gateway) SetActionGroups(actionGroups string) {
+	g.actionGroups.Store(&actionGroups)
+}
+
+// SetDeviceStates sets the json states that are served for the device with the given device url.
+func (g *Gateway) SetDeviceStates(deviceURL string, states string) {
+	g.states.Store(deviceURL, states)
+}
+
+// Settings returns the settings to connect to the gateway with the name "test".
+func (g *Gateway) Settings() *domain.Gateway {
+	host, port, _ := net.SplitHostPort(g.server.Listener.Addr().String())
+	portNumber, _ := strconv.Atoi(port)
+	return &domain.Gateway{
+		Name:               "test",
+		Token:              "token",
+		Host:               host,
+		Port:               uint16(portNumber),
+		PollInterval:       time.Minute,
+		RequestTimeout:     time.Second,
+		RetryInterval:      time.Minute,
+		MaxRetryInterval:   time.Minute,
+		InsecureSkipVerify: true,
+	}
+}
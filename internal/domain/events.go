@@ -0,0 +1,159 @@
+package domain
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"overkiz-adapter/internal/log"
+	"time"
+)
+
+const (
+	eventFetchInterval = time.Second * 2
+	eventRetryInterval = time.Second * 30
+)
+
+// Event is an event that is sent by the gateway to a registered event listener.
+type Event struct {
+	Name           string           `json:"name"`
+	Timestamp      int64            `json:"timestamp"`
+	DeviceURL      string           `json:"deviceURL,omitempty"`
+	DeviceStates   []*State         `json:"deviceStates,omitempty"`
+	ExecId         string           `json:"execId,omitempty"`
+	NewState       string           `json:"newState,omitempty"`
+	OldState       string           `json:"oldState,omitempty"`
+	FailureType    string           `json:"failureType,omitempty"`
+	FailedCommands []*FailedCommand `json:"-"`
+	// Device is the removed device of a DeviceRemovedEvent, as it was known before it was removed.
+	Device *Device `json:"-"`
+}
+
+// UnmarshalJSON decodes an event as it is sent by the gateway, which uses other names for the fields of the failed
+// commands than the api of the adapter.
+func (e *Event) UnmarshalJSON(data []byte) error {
+	type event Event
+	var response struct {
+		*event
+		FailedCommands []*failedCommandResponse `json:"failedCommands"`
+	}
+	response.event = (*event)(e)
+	err := json.Unmarshal(data, &response)
+	if err != nil {
+		return err
+	}
+	e.FailedCommands = nil
+	for _, failedCommand := range response.FailedCommands {
+		e.FailedCommands = append(e.FailedCommands, &FailedCommand{
+			DeviceURL:   failedCommand.DeviceURL,
+			Command:     failedCommand.Command,
+			Rank:        failedCommand.Rank,
+			FailureType: failedCommand.FailureType,
+		})
+	}
+	return nil
+}
+
+// listenEvents registers an event listener at the gateway and fetches its events until the context is done. When the
+// listener expires or the gateway is unreachable a new listener will be registered.
+func (o *Overkiz) listenEvents(ctx context.Context) {
+	listenerId := ""
+	defer func() {
+		if listenerId != "" {
+			o.unregisterEventListener(listenerId)
+		}
+	}()
+	for ctx.Err() == nil {
+		if listenerId == "" {
+			id, err := o.registerEventListener()
+			if err != nil {
+				log.Warningf("Failed to register event listener: %v", err)
+				sleep(ctx, eventRetryInterval)
+				continue
+			}
+			log.Debugf("Registered event listener %s", id)
+			listenerId = id
+		}
+		events, err := o.fetchEvents(listenerId)
+		if err != nil {
+			log.Warningf("Failed to fetch events of listener %s: %v", listenerId, err)
+			// The listener is most likely expired, a new one will be registered on the next iteration.
+			listenerId = ""
+			sleep(ctx, eventFetchInterval)
+			continue
+		}
+		for _, event := range events {
+			o.handleEvent(event)
+		}
+		sleep(ctx, eventFetchInterval)
+	}
+}
+
+func (o *Overkiz) registerEventListener() (string, error) {
+	var responseBody map[string]string
+	err := o.doRequest("POST", "/events/register", nil, &responseBody)
+	if err != nil {
+		return "", err
+	}
+	if responseBody["id"] == "" {
+		return "", fmt.Errorf("no listener id returned")
+	}
+	return responseBody["id"], nil
+}
+
+func (o *Overkiz) unregisterEventListener(listenerId string) {
+	err := o.doRequest("POST", fmt.Sprintf("/events/%s/unregister", listenerId), nil, nil)
+	if err != nil {
+		log.Debugf("Failed to unregister event listener %s: %v", listenerId, err)
+	}
+}
+
+func (o *Overkiz) fetchEvents(listenerId string) ([]*Event, error) {
+	events := make([]*Event, 0)
+	err := o.doRequest("POST", fmt.Sprintf("/events/%s/fetch", listenerId), nil, &events)
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+func (o *Overkiz) handleEvent(event *Event) {
+	log.Tracef("Received %s", event.Name)
+	switch event.Name {
+	case "DeviceStateChangedEvent":
+		o.states.update(event.DeviceURL, normalizeStates(event.DeviceStates))
+	case "ExecutionStateChangedEvent":
+		log.Debugf("Execution %s changed from %s to %s", event.ExecId, event.OldState, event.NewState)
+		o.executions.update(event.ExecId, event.NewState, event.FailureType, event.FailedCommands)
+	case "DeviceCreatedEvent":
+		log.Infof("Device %s created", event.DeviceURL)
+		o.refreshDevices()
+	case "DeviceRemovedEvent":
+		log.Infof("Device %s removed", event.DeviceURL)
+		event.Device = o.Device(event.DeviceURL)
+		o.states.remove(event.DeviceURL)
+		o.refreshDevices()
+	}
+	o.listenerLock.RLock()
+	defer o.listenerLock.RUnlock()
+	for _, listener := range o.listeners {
+		listener(event)
+	}
+}
+
+// AddEventListener registers a function that is called for every event that is received from the gateway. The
+// function is called after the devices, states and executions are updated with the event.
+func (o *Overkiz) AddEventListener(listener func(event *Event)) {
+	o.listenerLock.Lock()
+	defer o.listenerLock.Unlock()
+	o.listeners = append(o.listeners, listener)
+}
+
+// sleep waits for the given duration or until the context is done, whichever comes first.
+func sleep(ctx context.Context, duration time.Duration) {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
@@ -0,0 +1,86 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDeviceRemovedEvent(t *testing.T) {
+	o := &Overkiz{
+		apiUrl:  "http://127.0.0.1:0",
+		client:  &http.Client{},
+		gateway: &Gateway{},
+		states:  newStateCache(),
+	}
+	o.devices.Store(&deviceSnapshot{
+		devices: []*Device{{Label: "Bedroom", Class: "RollerShutter", DeviceURL: "io://1234-5678-9012/1"}},
+	})
+	var received *Event
+	o.AddEventListener(func(event *Event) {
+		received = event
+	})
+	o.handleEvent(&Event{Name: "DeviceRemovedEvent", DeviceURL: "io://1234-5678-9012/1"})
+	if received == nil || received.Device == nil || received.Device.Label != "Bedroom" {
+		t.Errorf("Expected the removed device in the event, got %+v", received)
+	}
+}
+
+func TestEventListenerExpiry(t *testing.T) {
+	var registrations atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/events/register", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, `{"id":"%d"}`, registrations.Add(1))
+	})
+	mux.HandleFunc("/events/1/fetch", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"errorCode":"UNSPECIFIED_ERROR","error":"No registered event listener"}`, http.StatusBadRequest)
+	})
+	mux.HandleFunc("/events/2/fetch", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, `[{"name":"DeviceStateChangedEvent","deviceURL":"io://1234-5678-9012/1",`+
+			`"deviceStates":[{"name":"core:ClosureState","type":1,"value":"100"}]}]`)
+	})
+	mux.HandleFunc("/events/2/unregister", func(w http.ResponseWriter, r *http.Request) {})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	o := &Overkiz{
+		apiUrl:  server.URL,
+		client:  server.Client(),
+		gateway: &Gateway{},
+		states:  newStateCache(),
+	}
+	o.devices.Store(&deviceSnapshot{
+		devices: []*Device{{Label: "Bedroom", Class: "RollerShutter", DeviceURL: "io://1234-5678-9012/1"}},
+	})
+	received := make(chan *Event, 10)
+	o.AddEventListener(func(event *Event) {
+		received <- event
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		o.listenEvents(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// The expired listener is replaced by a new one.
+	select {
+	case event := <-received:
+		if event.DeviceURL != "io://1234-5678-9012/1" || registrations.Load() != 2 {
+			t.Errorf("Expected an event of the second listener, got %+v after %d registrations", event, registrations.Load())
+		}
+		if states := o.Device("Bedroom").States; len(states) != 1 || states[0].Name != "core:ClosureState" {
+			t.Errorf("Expected the state of the event to be cached, got %v", states)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected an event after registering again, got %d registrations", registrations.Load())
+	}
+}
@@ -0,0 +1,183 @@
+package domain
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	ExecutionCompleted = "COMPLETED"
+	ExecutionFailed    = "FAILED"
+
+	// executionRetention is the time a finished execution is remembered.
+	executionRetention = time.Hour
+	// maxExecutionTime is the time after which an execution that did not finish is forgotten, for example because its
+	// final event was missed.
+	maxExecutionTime = time.Hour * 24
+)
+
+// Execution is a set of commands that is executed by the gateway.
+type Execution struct {
+	Id             string           `json:"id"`
+	Label          string           `json:"label"`
+	State          string           `json:"state"`
+	StartTime      time.Time        `json:"start_time"`
+	EndTime        *time.Time       `json:"end_time,omitempty"`
+	DeviceURLs     []string         `json:"device_urls"`
+	FailureType    string           `json:"failure_type,omitempty"`
+	FailedCommands []*FailedCommand `json:"failed_commands,omitempty"`
+}
+
+// FailedCommand is a command of an execution that failed on a specific device.
+type FailedCommand struct {
+	DeviceURL   string `json:"device_url"`
+	Command     string `json:"command"`
+	Rank        int    `json:"rank"`
+	FailureType string `json:"failure_type"`
+}
+
+// Finished returns true when the execution is either completed or failed.
+func (e *Execution) Finished() bool {
+	return e.State == ExecutionCompleted || e.State == ExecutionFailed
+}
+
+// executionTracker keeps track of the executions that are started by the adapter.
+type executionTracker struct {
+	lock       sync.RWMutex
+	executions map[string]*Execution
+}
+
+func newExecutionTracker() *executionTracker {
+	return &executionTracker{
+		executions: make(map[string]*Execution),
+	}
+}
+
+func (t *executionTracker) start(execId string, label string, deviceURLs []string) *Execution {
+	execution := &Execution{
+		Id:         execId,
+		Label:      label,
+		State:      "INITIALIZED",
+		StartTime:  time.Now(),
+		DeviceURLs: deviceURLs,
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.prune()
+	// The event of the execution might already be processed, in that case the tracked state takes precedence.
+	if tracked, ok := t.executions[execId]; ok {
+		tracked.Label = label
+		tracked.DeviceURLs = deviceURLs
+		copied := *tracked
+		return &copied
+	}
+	t.executions[execId] = execution
+	copied := *execution
+	return &copied
+}
+
+func (t *executionTracker) update(execId string, state string, failureType string, failedCommands []*FailedCommand) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.prune()
+	execution, ok := t.executions[execId]
+	if !ok {
+		execution = &Execution{
+			Id:        execId,
+			StartTime: time.Now(),
+		}
+		t.executions[execId] = execution
+	}
+	execution.State = state
+	if failureType != "" {
+		execution.FailureType = failureType
+	}
+	if len(failedCommands) > 0 {
+		execution.FailedCommands = failedCommands
+	}
+	if execution.Finished() && execution.EndTime == nil {
+		now := time.Now()
+		execution.EndTime = &now
+	}
+}
+
+// get returns a copy of the execution with the given id, or nil when the execution is unknown.
+func (t *executionTracker) get(execId string) *Execution {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	execution, ok := t.executions[execId]
+	if !ok {
+		return nil
+	}
+	copied := *execution
+	return &copied
+}
+
+// prune removes the finished executions that are older than the retention time, and the executions that never finished
+// and were started longer than the maximum execution time ago. The caller must hold the lock.
+func (t *executionTracker) prune() {
+	for execId, execution := range t.executions {
+		if execution.EndTime != nil && time.Since(*execution.EndTime) > executionRetention {
+			delete(t.executions, execId)
+		} else if execution.EndTime == nil && time.Since(execution.StartTime) > maxExecutionTime {
+			delete(t.executions, execId)
+		}
+	}
+}
+
+// HasExecution returns true when the execution with the given id was started by the adapter on this gateway, or when
+// events of the execution were received from this gateway.
+func (o *Overkiz) HasExecution(execId string) bool {
+	return o.TrackedExecution(execId) != nil
+}
+
+// TrackedExecution returns the execution with the given id as it is known by the adapter, without querying the
+// gateway. When the execution is unknown nil is returned.
+func (o *Overkiz) TrackedExecution(execId string) *Execution {
+	return o.executions.get(execId)
+}
+
+// Execution returns the execution with the given id, or nil when the execution is unknown. Executions that are
+// still running are queried at the gateway, finished executions are reported from the event stream.
+func (o *Overkiz) Execution(execId string) (*Execution, error) {
+	tracked := o.executions.get(execId)
+	if tracked != nil && tracked.Finished() {
+		return tracked, nil
+	}
+	var responseBody *executionResponse
+	err := o.doRequest("GET", fmt.Sprintf("/exec/current/%s", execId), nil, &responseBody)
+	if err != nil {
+		if tracked != nil {
+			return tracked, nil
+		}
+		return nil, err
+	}
+	if responseBody == nil || responseBody.Id == "" {
+		// The gateway only knows about running executions.
+		return tracked, nil
+	}
+	if tracked == nil {
+		return &Execution{
+			Id:        responseBody.Id,
+			Label:     responseBody.ActionGroup.Label,
+			State:     responseBody.State,
+			StartTime: time.UnixMilli(responseBody.StartTime),
+		}, nil
+	}
+	if tracked.State != responseBody.State {
+		o.executions.update(execId, responseBody.State, "", nil)
+		tracked.State = responseBody.State
+	}
+	return tracked, nil
+}
+
+// CancelExecution cancels the running execution with the given id.
+func (o *Overkiz) CancelExecution(execId string) error {
+	return o.doRequest("DELETE", fmt.Sprintf("/exec/current/setup/%s", execId), nil, nil)
+}
+
+// CancelExecutions cancels all running executions.
+func (o *Overkiz) CancelExecutions() error {
+	return o.doRequest("DELETE", "/exec/current/setup", nil, nil)
+}
@@ -0,0 +1,54 @@
+package domain
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFailedCommands(t *testing.T) {
+	var event *Event
+	err := json.Unmarshal([]byte(`{"name":"ExecutionStateChangedEvent","execId":"1","newState":"FAILED","failureType":"CMDCANCELLED",`+
+		`"failedCommands":[{"deviceURL":"io://1234-5678-9012/1","command":"close","rank":0,"failureType":"CMDCANCELLED"}]}`), &event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.ExecId != "1" || event.NewState != ExecutionFailed || len(event.FailedCommands) != 1 {
+		t.Fatalf("Unexpected event %+v", event)
+	}
+	if event.FailedCommands[0].DeviceURL != "io://1234-5678-9012/1" || event.FailedCommands[0].FailureType != "CMDCANCELLED" {
+		t.Errorf("Unexpected failed command %+v", event.FailedCommands[0])
+	}
+
+	tracker := newExecutionTracker()
+	tracker.update(event.ExecId, event.NewState, event.FailureType, event.FailedCommands)
+	data, _ := json.Marshal(tracker.get("1"))
+	if !strings.Contains(string(data), `"failed_commands":[{"device_url":"io://1234-5678-9012/1","command":"close","rank":0,"failure_type":"CMDCANCELLED"}]`) {
+		t.Errorf("Unexpected execution %s", data)
+	}
+}
+
+func TestPruneExecutions(t *testing.T) {
+	tracker := newExecutionTracker()
+	tracker.start("finished", "close", nil)
+	tracker.update("finished", ExecutionCompleted, "", nil)
+	tracker.update("running", "IN_PROGRESS", "", nil)
+	tracker.update("stale", "IN_PROGRESS", "", nil)
+	finished := time.Now().Add(-executionRetention - time.Minute)
+	tracker.executions["finished"].EndTime = &finished
+	tracker.executions["stale"].StartTime = time.Now().Add(-maxExecutionTime - time.Minute)
+
+	// Executions that are only seen in events are pruned as well, without starting an execution.
+	tracker.update("other", "IN_PROGRESS", "", nil)
+	for _, execId := range []string{"finished", "stale"} {
+		if tracker.get(execId) != nil {
+			t.Errorf("Expected execution %s to be pruned", execId)
+		}
+	}
+	for _, execId := range []string{"running", "other"} {
+		if tracker.get(execId) == nil {
+			t.Errorf("Expected execution %s to be kept", execId)
+		}
+	}
+}
@@ -3,87 +3,212 @@ package domain
 import (
 	"bytes"
 	"context"
-	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
+	"overkiz-adapter/internal/log"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type Overkiz struct {
-	token        string
-	apiUrl       string
-	client       *http.Client
-	devices      []*Device
-	updateTicker *time.Ticker
+	token         string
+	apiUrl        string
+	client        *http.Client
+	devices       atomic.Pointer[deviceSnapshot]
+	refreshLock   sync.Mutex
+	states        *stateCache
+	executions    *executionTracker
+	scenes        []*Scene
+	scenesPending bool
+	gateway       *Gateway
+	listenerLock  sync.RWMutex
+	listeners     []func(event *Event)
+}
+
+// Gateway holds the settings of the connection with a gateway.
+type Gateway struct {
+	Name                   string
+	Token                  string
+	Host                   string
+	Pin                    string
+	Port                   uint16
+	BasePath               string
+	PollInterval           time.Duration
+	RequestTimeout         time.Duration
+	RetryInterval          time.Duration
+	MaxRetryInterval       time.Duration
+	CaFile                 string
+	CertificateFingerprint string
+	ServerName             string
+	InsecureSkipVerify     bool
 }
 
 type Device struct {
-	Label     string `json:"label"`
-	Class     string `json:"class"`
-	DeviceURL string `json:"device_url"`
+	Label     string   `json:"label"`
+	Class     string   `json:"class"`
+	DeviceURL string   `json:"device_url"`
+	Gateway   string   `json:"gateway"`
+	States    []*State `json:"states,omitempty"`
 }
 
-func NewOverkiz(token string, host string, context context.Context) (*Overkiz, error) {
+// deviceSnapshot is an immutable view on the devices of the gateway. Every refresh swaps in a new snapshot, so readers
+// never see a partially updated device list.
+type deviceSnapshot struct {
+	devices     []*Device
+	lastRefresh time.Time
+	lastError   error
+}
+
+func NewOverkiz(gateway *Gateway, context context.Context) (*Overkiz, error) {
+	host := gateway.Host
+	serverName := gateway.ServerName
+	if host == "" {
+		discoveredHost, discoveredServerName, err := discoverHost(gateway)
+		if err != nil {
+			return nil, err
+		}
+		host = discoveredHost
+		if serverName == "" {
+			serverName = discoveredServerName
+		}
+	}
+	if serverName == "" && gateway.Pin != "" {
+		serverName = fmt.Sprintf("gateway-%s.local", gateway.Pin)
+	}
+	if serverName == "" {
+		serverName = host
+	}
 	o := &Overkiz{
-		token:  token,
-		apiUrl: fmt.Sprintf("https://%s:8443/enduser-mobile-web/1/enduserAPI", host),
+		token:      gateway.Token,
+		apiUrl:     fmt.Sprintf("https://%s%s", net.JoinHostPort(host, strconv.Itoa(int(gateway.Port))), gateway.BasePath),
+		states:     newStateCache(),
+		executions: newExecutionTracker(),
+		gateway:    gateway,
+	}
+	tlsConfig, err := newTLSConfig(serverName, gateway)
+	if err != nil {
+		return nil, err
 	}
 	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		TLSClientConfig: tlsConfig,
 	}
-	o.client = &http.Client{Transport: tr}
-	o.updateTicker = time.NewTicker(time.Minute * 5)
-	devices, err := o.loadDevices()
+	o.client = &http.Client{
+		Transport: tr,
+		Timeout:   gateway.RequestTimeout,
+	}
+	o.devices.Store(&deviceSnapshot{})
+	err = o.RefreshDevices()
 	if err != nil {
-		fmt.Printf("Failed to load devices: %v", err)
-	} else {
-		o.devices = devices
-	}
-	go func() {
-		for {
-			select {
-			case <-context.Done():
-				return
-			case <-o.updateTicker.C:
-				devices, err := o.loadDevices()
-				if err != nil {
-					fmt.Printf("Failed to load devices: %v", err)
-				} else {
-					o.devices = devices
-				}
-			}
-		}
-	}()
+		log.Warningf("Failed to load devices: %v", err)
+	}
+	go o.pollDevices(context, err)
+	go o.listenEvents(context)
 	return o, nil
 }
 
-func (o *Overkiz) loadDevices() ([]*Device, error) {
-	req, err := http.NewRequest("GET", fmt.Sprintf("%s/setup/devices", o.apiUrl), nil)
+// pollDevices refreshes the devices every poll interval until the context is done. After a failed refresh the next
+// attempt is done after the retry interval, which doubles on every consecutive failure up to the max retry interval.
+// The given error is the result of the initial refresh.
+func (o *Overkiz) pollDevices(ctx context.Context, err error) {
+	failures := 0
+	for {
+		interval := o.gateway.PollInterval
+		if err != nil {
+			failures++
+			interval = o.retryInterval(failures)
+			log.Debugf("Retrying to load devices in %v", interval)
+		} else {
+			failures = 0
+		}
+		sleep(ctx, interval)
+		if ctx.Err() != nil {
+			return
+		}
+		err = o.RefreshDevices()
+		if err != nil {
+			log.Warningf("Failed to load devices: %v", err)
+		}
+	}
+}
+
+func (o *Overkiz) retryInterval(failures int) time.Duration {
+	interval := o.gateway.RetryInterval
+	for i := 1; i < failures && interval < o.gateway.MaxRetryInterval; i++ {
+		interval *= 2
+	}
+	return min(interval, o.gateway.MaxRetryInterval)
+}
+
+// Name returns the name of the gateway.
+func (o *Overkiz) Name() string {
+	return o.gateway.Name
+}
+
+func (o *Overkiz) refreshDevices() {
+	err := o.RefreshDevices()
 	if err != nil {
-		return nil, err
+		log.Warningf("Failed to load devices: %v", err)
 	}
-	req.Header.Set("Authorization", "Bearer "+o.token)
-	resp, err := o.client.Do(req)
+}
+
+// RefreshDevices reloads the devices from the gateway. When the devices cannot be loaded the previously loaded devices
+// are kept and the error is remembered.
+func (o *Overkiz) RefreshDevices() error {
+	o.refreshLock.Lock()
+	defer o.refreshLock.Unlock()
+	current := o.devices.Load()
+	devices, err := o.loadDevices()
 	if err != nil {
-		return nil, err
+		o.devices.Store(&deviceSnapshot{
+			devices:     current.devices,
+			lastRefresh: current.lastRefresh,
+			lastError:   err,
+		})
+		return err
 	}
-	body, _ := io.ReadAll(resp.Body)
-	if resp.StatusCode != http.StatusOK {
-		println(string(body))
+	o.states.replace(devices)
+	o.devices.Store(&deviceSnapshot{
+		devices:     devices,
+		lastRefresh: time.Now(),
+	})
+	if o.scenesPending {
+		o.scenesPending = false
+		err = o.validateScenes(o.scenes)
+		if err != nil {
+			log.Errorf("Invalid scene configuration for gateway %s: %v", o.Name(), err)
+		}
 	}
-	var responseBody []map[string]any
-	err = json.Unmarshal(body, &responseBody)
+	return nil
+}
+
+// RefreshStatus returns the time of the last successful refresh of the devices, and the error of the last refresh when
+// that refresh failed.
+func (o *Overkiz) RefreshStatus() (time.Time, error) {
+	snapshot := o.devices.Load()
+	return snapshot.lastRefresh, snapshot.lastError
+}
+
+func (o *Overkiz) loadDevices() ([]*Device, error) {
+	var responseBody []*deviceResponse
+	err := o.doRequest("GET", "/setup/devices", nil, &responseBody)
 	if err != nil {
 		return nil, err
 	}
 	devices := make([]*Device, 0)
 	for _, device := range responseBody {
 		devices = append(devices, &Device{
-			Label:     device["label"].(string),
-			DeviceURL: device["deviceURL"].(string),
-			Class:     (device["definition"].(map[string]any))["uiClass"].(string),
+			Label:     device.Label,
+			DeviceURL: device.DeviceURL,
+			Class:     device.Definition.UiClass,
+			Gateway:   o.gateway.Name,
+			States:    normalizeStates(device.States),
 		})
 	}
 	return devices, nil
@@ -91,57 +216,148 @@ func (o *Overkiz) loadDevices() ([]*Device, error) {
 
 func (o *Overkiz) Devices(class string) []*Device {
 	result := make([]*Device, 0)
-	if class == "" {
-		return o.devices
-	}
-	for _, device := range o.devices {
-		if device.Class == class {
-			result = append(result, device)
+	for _, device := range o.devices.Load().devices {
+		if class == "" || device.Class == class {
+			result = append(result, o.withStates(device))
 		}
 	}
 	return result
 }
 
-func (o *Overkiz) RollerShutters(actionName string, parameters []string) (int, error) {
-	devices := o.Devices("RollerShutter")
+func (o *Overkiz) RollerShutters(actionName string, parameters []any) (*Execution, error) {
+	return o.ExecuteCommand("RollerShutter", actionName, parameters)
+}
+
+// ExecuteCommand executes the command with the given name and parameters on all devices of the given class. When there
+// are no devices of the given class nil is returned.
+func (o *Overkiz) ExecuteCommand(class string, commandName string, parameters []any) (*Execution, error) {
+	devices := o.Devices(class)
 	if len(devices) == 0 {
-		return 0, nil
+		return nil, nil
+	}
+	return o.execute(commandName+class+"s", devices, commandName, parameters)
+}
+
+// Device returns the device that has the given deviceURL or label, or nil when no such device exists. Labels are
+// matched case-insensitive, a matching deviceURL takes precedence over a matching label.
+func (o *Overkiz) Device(identifier string) *Device {
+	return o.ClassDevice("", identifier)
+}
+
+// ClassDevice returns the device of the given class that has the given deviceURL or label, like Device. Devices of all
+// classes are matched when the class is empty.
+func (o *Overkiz) ClassDevice(class string, identifier string) *Device {
+	var labelMatch *Device
+	for _, device := range o.devices.Load().devices {
+		if class != "" && device.Class != class {
+			continue
+		}
+		if device.DeviceURL == identifier {
+			return o.withStates(device)
+		}
+		if labelMatch == nil && strings.EqualFold(device.Label, identifier) {
+			labelMatch = device
+		}
+	}
+	if labelMatch == nil {
+		return nil
+	}
+	return o.withStates(labelMatch)
+}
+
+// withStates returns a copy of the given device with the states from the state cache.
+func (o *Overkiz) withStates(device *Device) *Device {
+	result := *device
+	result.States = o.states.get(device.DeviceURL)
+	return &result
+}
+
+// DeviceStates retrieves the current states of the given device from the gateway.
+func (o *Overkiz) DeviceStates(device *Device) ([]*State, error) {
+	states := make([]*State, 0)
+	err := o.doRequest("GET", fmt.Sprintf("/setup/devices/%s/states", url.PathEscape(device.DeviceURL)), nil, &states)
+	if err != nil {
+		return nil, err
 	}
+	states = normalizeStates(states)
+	o.states.update(device.DeviceURL, states)
+	return states, nil
+}
+
+// ExecuteDeviceCommand executes the command with the given name and parameters on a single device.
+func (o *Overkiz) ExecuteDeviceCommand(device *Device, commandName string, parameters []any) (*Execution, error) {
+	return o.execute(commandName+device.Label, []*Device{device}, commandName, parameters)
+}
+
+func (o *Overkiz) execute(label string, devices []*Device, commandName string, parameters []any) (*Execution, error) {
 	ar := &actionRequest{
-		Label: actionName + "RollerShutters",
+		Label: label,
 	}
 	for _, device := range devices {
 		ac := &action{
 			DeviceURL: device.DeviceURL,
 		}
 		ac.Commands = append(ac.Commands, &command{
-			Name:       actionName,
+			Name:       commandName,
 			Parameters: parameters,
 		})
 		ar.Actions = append(ar.Actions, ac)
 	}
-	reqData, err := json.Marshal(ar)
+	return o.apply(ar)
+}
+
+// apply sends the action request to the gateway and starts tracking the resulting execution.
+func (o *Overkiz) apply(ar *actionRequest) (*Execution, error) {
+	var responseBody map[string]string
+	err := o.doRequest("POST", "/exec/apply", ar, &responseBody)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	req, err := http.NewRequest("POST", fmt.Sprintf("%s/exec/apply", o.apiUrl), bytes.NewBuffer(reqData))
+	if responseBody["execId"] == "" {
+		return nil, fmt.Errorf("no execution id returned")
+	}
+	deviceURLs := make([]string, 0, len(ar.Actions))
+	for _, ac := range ar.Actions {
+		deviceURLs = append(deviceURLs, ac.DeviceURL)
+	}
+	return o.executions.start(responseBody["execId"], ar.Label, deviceURLs), nil
+}
+
+// doRequest sends a request to the given path of the local api. When body is not nil it is sent as json, when result is
+// not nil the json response is decoded into it.
+func (o *Overkiz) doRequest(method string, path string, body any, result any) error {
+	var reader io.Reader
+	if body != nil {
+		reqData, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(reqData)
+	}
+	req, err := http.NewRequest(method, o.apiUrl+path, reader)
 	if err != nil {
-		return 0, err
+		return err
 	}
 	req.Header.Set("Authorization", "Bearer "+o.token)
-	req.Header.Set("Content-Type", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
 	resp, err := o.client.Do(req)
 	if err != nil {
-		return 0, err
+		return err
+	}
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(resp.Body)
+	respData, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
 	}
-	body, _ := io.ReadAll(resp.Body)
 	if resp.StatusCode != http.StatusOK {
-		return 0, fmt.Errorf("%s", string(body))
+		return fmt.Errorf("%s: %s", resp.Status, string(respData))
 	}
-	var responseBody map[string]any
-	err = json.Unmarshal(body, &responseBody)
-	if err != nil {
-		return 0, err
+	if result == nil || len(respData) == 0 {
+		return nil
 	}
-	return len(devices), nil
+	return json.Unmarshal(respData, result)
 }
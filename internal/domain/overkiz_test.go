@@ -0,0 +1,116 @@
+package domain
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestClassDevice(t *testing.T) {
+	o := &Overkiz{
+		gateway: &Gateway{},
+		states:  newStateCache(),
+	}
+	// A light and a shutter share the label of their room.
+	o.devices.Store(&deviceSnapshot{
+		devices: []*Device{
+			{Label: "Bedroom", Class: "Light", DeviceURL: "io://1234-5678-9012/2"},
+			{Label: "Bedroom", Class: "RollerShutter", DeviceURL: "io://1234-5678-9012/1"},
+		},
+	})
+	tests := []struct {
+		class      string
+		identifier string
+		expected   string
+	}{
+		{"RollerShutter", "Bedroom", "io://1234-5678-9012/1"},
+		{"Light", "Bedroom", "io://1234-5678-9012/2"},
+		{"", "Bedroom", "io://1234-5678-9012/2"},
+		{"RollerShutter", "io://1234-5678-9012/1", "io://1234-5678-9012/1"},
+		{"Light", "io://1234-5678-9012/1", ""},
+		{"Awning", "Bedroom", ""},
+	}
+	for _, test := range tests {
+		device := o.ClassDevice(test.class, test.identifier)
+		if test.expected == "" && device != nil {
+			t.Errorf("Expected no %s %s, got %s", test.class, test.identifier, device.DeviceURL)
+		} else if test.expected != "" && (device == nil || device.DeviceURL != test.expected) {
+			t.Errorf("Expected %s for %s %s, got %v", test.expected, test.class, test.identifier, device)
+		}
+	}
+}
+
+func TestRetryInterval(t *testing.T) {
+	o := &Overkiz{gateway: &Gateway{RetryInterval: 30 * time.Second, MaxRetryInterval: 5 * time.Minute}}
+	tests := []struct {
+		failures int
+		expected time.Duration
+	}{
+		{0, 30 * time.Second},
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{4, 4 * time.Minute},
+		{5, 5 * time.Minute},
+		{100, 5 * time.Minute},
+	}
+	for _, test := range tests {
+		if interval := o.retryInterval(test.failures); interval != test.expected {
+			t.Errorf("Expected %v after %d failures, got %v", test.expected, test.failures, interval)
+		}
+	}
+}
+
+func TestApiUrl(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tests := []struct {
+		host     string
+		port     uint16
+		basePath string
+		expected string
+	}{
+		{"127.0.0.1", 8443, "/enduser-mobile-web/1/enduserAPI", "https://127.0.0.1:8443/enduser-mobile-web/1/enduserAPI"},
+		{"127.0.0.1", 1, "/api", "https://127.0.0.1:1/api"},
+		{"::1", 8443, "", "https://[::1]:8443"},
+	}
+	for _, test := range tests {
+		// Nothing listens on the gateway, the devices are loaded later.
+		o, err := NewOverkiz(&Gateway{Host: test.host, Port: test.port, BasePath: test.basePath, PollInterval: time.Minute,
+			RequestTimeout: 100 * time.Millisecond, RetryInterval: time.Minute, MaxRetryInterval: time.Minute}, ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if o.apiUrl != test.expected {
+			t.Errorf("Expected api url %s, got %s", test.expected, o.apiUrl)
+		}
+	}
+}
+
+func TestServerName(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tests := []struct {
+		host       string
+		pin        string
+		serverName string
+		expected   string
+	}{
+		{"192.168.1.20", "1234-5678-9012", "", "gateway-1234-5678-9012.local"},
+		{"192.168.1.20", "1234-5678-9012", "gateway.home", "gateway.home"},
+		{"192.168.1.20", "", "", "192.168.1.20"},
+		{"gateway-1234-5678-9012.local", "", "", "gateway-1234-5678-9012.local"},
+	}
+	for _, test := range tests {
+		// Nothing answers on the port, the devices are loaded later.
+		o, err := NewOverkiz(&Gateway{Host: test.host, Port: 1, Pin: test.pin, ServerName: test.serverName,
+			PollInterval: time.Minute, RequestTimeout: 100 * time.Millisecond, RetryInterval: time.Minute,
+			MaxRetryInterval: time.Minute}, ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if serverName := o.client.Transport.(*http.Transport).TLSClientConfig.ServerName; serverName != test.expected {
+			t.Errorf("Expected server name %s for %s with pin %s, got %s", test.expected, test.host, test.pin, serverName)
+		}
+	}
+}
@@ -11,6 +11,6 @@ type action struct {
 }
 
 type command struct {
-	Name       string   `json:"name"`
-	Parameters []string `json:"parameters,omitempty"`
+	Name       string `json:"name"`
+	Parameters []any  `json:"parameters,omitempty"`
 }
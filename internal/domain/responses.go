@@ -0,0 +1,32 @@
+package domain
+
+type deviceResponse struct {
+	Label      string             `json:"label"`
+	DeviceURL  string             `json:"deviceURL"`
+	Definition definitionResponse `json:"definition"`
+	States     []*State           `json:"states"`
+}
+
+type definitionResponse struct {
+	UiClass string `json:"uiClass"`
+}
+
+type executionResponse struct {
+	Id          string              `json:"id"`
+	State       string              `json:"state"`
+	StartTime   int64               `json:"startTime"`
+	ActionGroup actionGroupResponse `json:"actionGroup"`
+}
+
+type actionGroupResponse struct {
+	Oid     string    `json:"oid"`
+	Label   string    `json:"label"`
+	Actions []*action `json:"actions"`
+}
+
+type failedCommandResponse struct {
+	DeviceURL   string `json:"deviceURL"`
+	Command     string `json:"command"`
+	Rank        int    `json:"rank"`
+	FailureType string `json:"failureType"`
+}
@@ -0,0 +1,117 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"overkiz-adapter/internal/log"
+	"strings"
+)
+
+// Scene is a named set of actions that are executed as a single execution on a gateway. A scene without a gateway
+// belongs to the gateway without a name.
+type Scene struct {
+	Name    string         `json:"name"`
+	Gateway string         `json:"gateway"`
+	Actions []*SceneAction `json:"actions"`
+}
+
+type SceneAction struct {
+	Device     string `json:"device"`
+	Command    string `json:"command"`
+	Parameters []any  `json:"parameters"`
+}
+
+// SetScenes sets the scenes of this gateway from the given scenes, an error is returned when a scene is defined more
+// than once or refers to an unknown device. When the devices are not loaded yet the devices of the scenes are validated
+// after the first successful refresh of the devices, and an invalid scene is logged instead.
+func (o *Overkiz) SetScenes(allScenes []*Scene) error {
+	o.refreshLock.Lock()
+	defer o.refreshLock.Unlock()
+	scenes := make([]*Scene, 0)
+	for _, scene := range allScenes {
+		if scene.Gateway == o.Name() {
+			scenes = append(scenes, scene)
+		}
+	}
+	var errs []error
+	names := make(map[string]struct{}, len(scenes))
+	for _, scene := range scenes {
+		name := strings.ToLower(scene.Name)
+		if _, ok := names[name]; ok {
+			errs = append(errs, fmt.Errorf("scene %s is defined more than once", scene.Name))
+		}
+		names[name] = struct{}{}
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	if o.devices.Load().lastRefresh.IsZero() {
+		log.Warning("No devices loaded, the devices of the scenes are validated when the devices are loaded")
+		o.scenes = scenes
+		o.scenesPending = true
+		return nil
+	}
+	err := o.validateScenes(scenes)
+	if err != nil {
+		return err
+	}
+	o.scenes = scenes
+	return nil
+}
+
+// validateScenes returns an error for every action of the given scenes that refers to an unknown device.
+func (o *Overkiz) validateScenes(scenes []*Scene) error {
+	var errs []error
+	for _, scene := range scenes {
+		for _, sceneAction := range scene.Actions {
+			if o.Device(sceneAction.Device) == nil {
+				errs = append(errs, fmt.Errorf("scene %s refers to unknown device %s", scene.Name, sceneAction.Device))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Scenes returns the scenes of this gateway.
+func (o *Overkiz) Scenes() []*Scene {
+	return o.scenes
+}
+
+// ExecuteScene executes all actions of the scene with the given name as a single execution. Names are matched
+// case-insensitive. When no such scene exists nil is returned.
+func (o *Overkiz) ExecuteScene(name string) (*Execution, error) {
+	var scene *Scene
+	for _, s := range o.scenes {
+		if strings.EqualFold(s.Name, name) {
+			scene = s
+			break
+		}
+	}
+	if scene == nil {
+		return nil, nil
+	}
+	ar := &actionRequest{
+		Label: scene.Name,
+	}
+	actions := make(map[string]*action)
+	for _, sceneAction := range scene.Actions {
+		device := o.Device(sceneAction.Device)
+		if device == nil {
+			return nil, fmt.Errorf("scene %s refers to unknown device %s", scene.Name, sceneAction.Device)
+		}
+		// Multiple commands for the same device are sent in a single action.
+		ac, ok := actions[device.DeviceURL]
+		if !ok {
+			ac = &action{
+				DeviceURL: device.DeviceURL,
+			}
+			actions[device.DeviceURL] = ac
+			ar.Actions = append(ar.Actions, ac)
+		}
+		ac.Commands = append(ac.Commands, &command{
+			Name:       sceneAction.Command,
+			Parameters: sceneAction.Parameters,
+		})
+	}
+	return o.apply(ar)
+}
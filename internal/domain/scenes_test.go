@@ -0,0 +1,42 @@
+package domain
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSetScenes(t *testing.T) {
+	o := &Overkiz{
+		gateway: &Gateway{},
+		states:  newStateCache(),
+	}
+	o.devices.Store(&deviceSnapshot{})
+	scenes := []*Scene{
+		{Name: "morning", Actions: []*SceneAction{{Device: "Bedroom", Command: "open"}}},
+		{Name: "Morning", Actions: []*SceneAction{{Device: "Bedroom", Command: "close"}}},
+	}
+	if o.SetScenes(scenes) == nil {
+		t.Error("Expected an error for a duplicate scene without loaded devices")
+	}
+
+	// Without loaded devices the devices of the scenes are validated later.
+	scenes = []*Scene{
+		{Name: "morning", Actions: []*SceneAction{{Device: "Bedroom", Command: "open"}}},
+		{Name: "evening", Gateway: "other", Actions: []*SceneAction{{Device: "Unknown", Command: "close"}}},
+	}
+	if err := o.SetScenes(scenes); err != nil || !o.scenesPending || len(o.Scenes()) != 1 {
+		t.Fatalf("Expected the scene to be validated later, got %v", err)
+	}
+
+	o.devices.Store(&deviceSnapshot{
+		devices:     []*Device{{Label: "Bedroom", Class: "RollerShutter", DeviceURL: "io://1234-5678-9012/1"}},
+		lastRefresh: time.Now(),
+	})
+	if err := o.SetScenes(scenes); err != nil {
+		t.Errorf("Expected the scene of a known device to be valid, got %v", err)
+	}
+	scenes[0].Actions[0].Device = "Unknown"
+	if o.SetScenes(scenes) == nil {
+		t.Error("Expected an error for an unknown device")
+	}
+}
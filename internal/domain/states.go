@@ -0,0 +1,119 @@
+package domain
+
+import (
+	"encoding/json"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	stateTypeInteger = 1
+	stateTypeFloat   = 2
+	stateTypeBoolean = 6
+	stateTypeArray   = 10
+	stateTypeObject  = 11
+)
+
+// State is the value of a single state of a device, for example the core:ClosureState of a RollerShutter.
+type State struct {
+	Name  string `json:"name"`
+	Type  int    `json:"type"`
+	Value any    `json:"value"`
+}
+
+// normalizeStates converts the string values the gateway sends in events to the type of the state.
+func normalizeStates(states []*State) []*State {
+	for _, state := range states {
+		value, ok := state.Value.(string)
+		if !ok {
+			continue
+		}
+		switch state.Type {
+		case stateTypeInteger:
+			if i, err := strconv.Atoi(value); err == nil {
+				state.Value = i
+			}
+		case stateTypeFloat:
+			if f, err := strconv.ParseFloat(value, 64); err == nil {
+				state.Value = f
+			}
+		case stateTypeBoolean:
+			if b, err := strconv.ParseBool(value); err == nil {
+				state.Value = b
+			}
+		case stateTypeArray, stateTypeObject:
+			var v any
+			if err := json.Unmarshal([]byte(value), &v); err == nil {
+				state.Value = v
+			}
+		}
+	}
+	return states
+}
+
+// stateCache holds the latest known states of all devices, keyed by deviceURL and state name.
+type stateCache struct {
+	lock   sync.RWMutex
+	states map[string]map[string]*State
+}
+
+func newStateCache() *stateCache {
+	return &stateCache{
+		states: make(map[string]map[string]*State),
+	}
+}
+
+// replace replaces the content of the cache with the states of the given devices.
+func (c *stateCache) replace(devices []*Device) {
+	states := make(map[string]map[string]*State, len(devices))
+	for _, device := range devices {
+		deviceStates := make(map[string]*State, len(device.States))
+		for _, state := range device.States {
+			deviceStates[state.Name] = state
+		}
+		states[device.DeviceURL] = deviceStates
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.states = states
+}
+
+// update merges the given states into the states of the device with the given deviceURL.
+func (c *stateCache) update(deviceURL string, states []*State) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	deviceStates, ok := c.states[deviceURL]
+	if !ok {
+		deviceStates = make(map[string]*State, len(states))
+		c.states[deviceURL] = deviceStates
+	}
+	for _, state := range states {
+		deviceStates[state.Name] = state
+	}
+}
+
+func (c *stateCache) remove(deviceURL string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.states, deviceURL)
+}
+
+// get returns the states of the device with the given deviceURL, sorted by name.
+func (c *stateCache) get(deviceURL string) []*State {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	deviceStates := c.states[deviceURL]
+	if len(deviceStates) == 0 {
+		return nil
+	}
+	result := make([]*State, 0, len(deviceStates))
+	for _, state := range deviceStates {
+		result = append(result, state)
+	}
+	slices.SortFunc(result, func(a, b *State) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return result
+}
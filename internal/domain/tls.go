@@ -0,0 +1,58 @@
+package domain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"overkiz-adapter/internal/log"
+	"strings"
+)
+
+// newTLSConfig creates the tls configuration that is used to connect to the gateway. The certificate of the gateway is
+// either pinned by its fingerprint or verified against the configured CA bundle (or the system CAs when no bundle is
+// configured) including the given server name.
+func newTLSConfig(serverName string, gateway *Gateway) (*tls.Config, error) {
+	if gateway.InsecureSkipVerify {
+		log.Warning("Verification of the gateway certificate is disabled, do not use this outside a test environment")
+		return &tls.Config{InsecureSkipVerify: true}, nil
+	}
+	tlsConfig := &tls.Config{
+		ServerName: serverName,
+	}
+	if gateway.CertificateFingerprint != "" {
+		fingerprint, err := hex.DecodeString(strings.ReplaceAll(gateway.CertificateFingerprint, ":", ""))
+		if err != nil || len(fingerprint) != sha256.Size {
+			return nil, fmt.Errorf("invalid certificate fingerprint %s, expected a sha256 fingerprint", gateway.CertificateFingerprint)
+		}
+		// The pinned certificate replaces the CA verification, the gateway certificate itself is trusted.
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+			if len(state.PeerCertificates) == 0 {
+				return errors.New("gateway did not present a certificate")
+			}
+			actual := sha256.Sum256(state.PeerCertificates[0].Raw)
+			if !bytes.Equal(actual[:], fingerprint) {
+				return fmt.Errorf("gateway certificate fingerprint %s does not match the configured fingerprint", hex.EncodeToString(actual[:]))
+			}
+			return nil
+		}
+		return tlsConfig, nil
+	}
+	if gateway.CaFile != "" {
+		pem, err := os.ReadFile(gateway.CaFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", gateway.CaFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return tlsConfig, nil
+}
@@ -0,0 +1,76 @@
+package domain
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTLSConfig(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fingerprint := sha256.Sum256(server.Certificate().Raw)
+
+	tests := []struct {
+		name       string
+		serverName string
+		gateway    *Gateway
+		connects   bool
+	}{
+		{"system CAs", "example.com", &Gateway{}, false},
+		{"CA file", "example.com", &Gateway{CaFile: caFile}, true},
+		{"CA file with ip address", "127.0.0.1", &Gateway{CaFile: caFile}, true},
+		{"CA file with other server name", "gateway-1234-5678-9012.local", &Gateway{CaFile: caFile}, false},
+		{"fingerprint", "gateway-1234-5678-9012.local", &Gateway{CertificateFingerprint: hex.EncodeToString(fingerprint[:])}, true},
+		{"fingerprint with colons", "", &Gateway{CertificateFingerprint: colons(hex.EncodeToString(fingerprint[:]))}, true},
+		{"other fingerprint", "example.com", &Gateway{CaFile: caFile, CertificateFingerprint: strings.Repeat("00", sha256.Size)}, false},
+		{"insecure", "gateway-1234-5678-9012.local", &Gateway{InsecureSkipVerify: true}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tlsConfig, err := newTLSConfig(test.serverName, test.gateway)
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+			response, err := client.Get(server.URL)
+			if err == nil {
+				_ = response.Body.Close()
+			}
+			if test.connects && err != nil {
+				t.Errorf("Expected to connect, got %v", err)
+			}
+			if !test.connects && err == nil {
+				t.Error("Expected the certificate to be rejected")
+			}
+		})
+	}
+
+	_, err = newTLSConfig("example.com", &Gateway{CertificateFingerprint: "abcd"})
+	if err == nil {
+		t.Error("Expected an error for an invalid fingerprint")
+	}
+	_, err = newTLSConfig("example.com", &Gateway{CaFile: filepath.Join(t.TempDir(), "missing.pem")})
+	if err == nil {
+		t.Error("Expected an error for a missing CA file")
+	}
+}
+
+func colons(fingerprint string) string {
+	pairs := make([]string, 0, len(fingerprint)/2)
+	for i := 0; i < len(fingerprint); i += 2 {
+		pairs = append(pairs, fingerprint[i:i+2])
+	}
+	return strings.Join(pairs, ":")
+}
@@ -0,0 +1,183 @@
+package http
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"github.com/go-chi/chi/v5/middleware"
+	"net/http"
+	"overkiz-adapter/internal/config"
+	"overkiz-adapter/internal/log"
+	"slices"
+	"strings"
+)
+
+const (
+	// scopeRead allows to list devices, states, executions, scenes, rules and schedules and to stream events.
+	scopeRead = "read"
+	// scopeExecute allows to execute actions and to cancel executions.
+	scopeExecute = "execute"
+	// scopeManage allows to enable and disable rules and to pause and skip schedules.
+	scopeManage = "manage"
+
+	identityContextKey contextKey = "identity"
+)
+
+// identity is the authenticated client of a request.
+type identity struct {
+	name   string
+	scopes []string
+	// queryKey is true when the client sent its api key in the key query parameter.
+	queryKey bool
+}
+
+// GenerateApiKey generates a random api key and returns it together with the hash that is configured for it.
+func GenerateApiKey() (string, string, error) {
+	data := make([]byte, 32)
+	_, err := rand.Read(data)
+	if err != nil {
+		return "", "", err
+	}
+	key := base64.RawURLEncoding.EncodeToString(data)
+	return key, HashApiKey(key), nil
+}
+
+// HashApiKey returns the hex encoded SHA-256 hash of the key.
+func HashApiKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+// authenticate is a middleware that only passes requests of known clients. A client is identified by its client
+// certificate, or by a valid api key in the bearer token of the Authorization header or in the key query parameter. The
+// identity of the client is stored in the context of the request. Only action routes accept a key in the query parameter,
+// see requireActionScope.
+func authenticate(apiKeys []*config.ApiKey, certificates []*config.ClientCertificate) func(next http.Handler) http.Handler {
+	hashes := make([][]byte, len(apiKeys))
+	for ix, apiKey := range apiKeys {
+		hashes[ix], _ = hex.DecodeString(strings.ToLower(apiKey.Hash))
+	}
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			if client := certificateIdentity(r, certificates); client != nil {
+				next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), identityContextKey, client)))
+				return
+			}
+			if len(apiKeys) == 0 {
+				logDenied(r, "unknown client certificate")
+				writeError(w, r, http.StatusForbidden, "Unknown client certificate")
+				return
+			}
+			key, queryKey := r.URL.Query().Get("key"), true
+			if authorization := r.Header.Get("Authorization"); authorization != "" {
+				scheme, token, _ := strings.Cut(authorization, " ")
+				if strings.EqualFold(scheme, "Bearer") {
+					key, queryKey = strings.TrimSpace(token), false
+				}
+			}
+			if key == "" {
+				logDenied(r, "missing api key")
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				writeError(w, r, http.StatusUnauthorized, "Missing api key")
+				return
+			}
+			hash := sha256.Sum256([]byte(key))
+			for ix, apiKey := range apiKeys {
+				if subtle.ConstantTimeCompare(hash[:], hashes[ix]) == 1 {
+					client := &identity{name: apiKey.Name, scopes: apiKey.Scopes, queryKey: queryKey}
+					next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), identityContextKey, client)))
+					return
+				}
+			}
+			logDenied(r, "invalid api key")
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			writeError(w, r, http.StatusUnauthorized, "Invalid api key")
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// certificateIdentity returns the identity of the client certificate of the request, or nil when the client did not
+// present a known certificate. Subjects are only matched when the certificate was verified with the client CA, either
+// with the common name or with the full distinguished name like CN=tablet,O=Home.
+func certificateIdentity(r *http.Request, certificates []*config.ClientCertificate) *identity {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil
+	}
+	certificate := r.TLS.PeerCertificates[0]
+	fingerprint := sha256.Sum256(certificate.Raw)
+	verified := len(r.TLS.VerifiedChains) > 0
+	for _, clientCertificate := range certificates {
+		if clientCertificate.Fingerprint != "" && clientCertificate.Fingerprint != hex.EncodeToString(fingerprint[:]) {
+			continue
+		}
+		if clientCertificate.Subject != "" && (!verified || (clientCertificate.Subject != certificate.Subject.CommonName &&
+			clientCertificate.Subject != certificate.Subject.String())) {
+			continue
+		}
+		return &identity{name: clientCertificate.Name, scopes: clientCertificate.Scopes}
+	}
+	return nil
+}
+
+// logIdentity is a middleware that logs every request at info level with its request id and the identity of the
+// client.
+func logIdentity(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		log.Info(requestLine(r))
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// logDenied logs a request that is denied access, in the same format as logIdentity.
+func logDenied(r *http.Request, reason string) {
+	log.Infof("%s denied: %s", requestLine(r), reason)
+}
+
+// requestLine describes the request with its request id and the identity of the client.
+func requestLine(r *http.Request) string {
+	name := "anonymous"
+	if client, ok := r.Context().Value(identityContextKey).(*identity); ok {
+		name = client.name
+	}
+	return fmt.Sprintf("Request %s %s %s by %s from %s", middleware.GetReqID(r.Context()), r.Method, r.URL.Path, name, r.RemoteAddr)
+}
+
+// requireScope is a middleware that only passes requests of clients that have the given scope. Without authentication
+// all requests are passed. An api key in the key query parameter is refused, because urls end up in the logs of proxies
+// and browsers.
+func (s *Server) requireScope(scope string) func(next http.Handler) http.Handler {
+	return s.authorize(scope, false)
+}
+
+// requireActionScope is requireScope for the action routes, which also accept an api key in the key query parameter for
+// clients that cannot set headers.
+func (s *Server) requireActionScope(scope string) func(next http.Handler) http.Handler {
+	return s.authorize(scope, true)
+}
+
+func (s *Server) authorize(scope string, allowQueryKey bool) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			client, ok := r.Context().Value(identityContextKey).(*identity)
+			if ok && client.queryKey && !allowQueryKey {
+				logDenied(r, "api key in the url")
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_request"`)
+				writeError(w, r, http.StatusUnauthorized, "Api key must be sent in the Authorization header")
+				return
+			}
+			if s.authenticated && (!ok || !slices.Contains(client.scopes, scope)) {
+				logDenied(r, fmt.Sprintf("scope %s required", scope))
+				writeError(w, r, http.StatusForbidden, fmt.Sprintf("Scope %s required", scope))
+				return
+			}
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
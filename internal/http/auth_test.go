@@ -0,0 +1,98 @@
+package http
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"overkiz-adapter/internal/config"
+	"path/filepath"
+	"testing"
+)
+
+func TestApiKeys(t *testing.T) {
+	httpConfig := &config.Http{ApiKeys: []*config.ApiKey{
+		{Name: "dashboard", Hash: HashApiKey("read-key"), Scopes: []string{scopeRead}},
+		{Name: "shelly", Hash: HashApiKey("execute-key"), Scopes: []string{scopeExecute}},
+		{Name: "admin", Hash: HashApiKey("manage-key"), Scopes: []string{scopeManage}},
+	}}
+	server := newTestServer(t, httpConfig, newTestGateway(t).Settings())
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		headers  []string
+		expected int
+	}{
+		{"missing key", "GET", "/api/v1/devices", nil, http.StatusUnauthorized},
+		{"bearer token", "GET", "/api/v1/devices", []string{"Authorization", "Bearer read-key"}, http.StatusOK},
+		{"invalid key", "GET", "/api/v1/devices", []string{"Authorization", "Bearer other-key"}, http.StatusUnauthorized},
+		{"other scheme", "GET", "/api/v1/devices", []string{"Authorization", "Basic read-key"}, http.StatusUnauthorized},
+		{"query key on a read route", "GET", "/api/v1/devices?key=read-key", nil, http.StatusUnauthorized},
+		{"query key on an action", "POST", "/api/v1/devices/RollerShutters/close?key=execute-key", nil, http.StatusAccepted},
+		{"invalid query key", "POST", "/api/v1/devices/RollerShutters/close?key=other-key", nil, http.StatusUnauthorized},
+		{"bearer token on an action", "POST", "/api/v1/device/Bedroom/open", []string{"Authorization", "Bearer execute-key"}, http.StatusAccepted},
+		{"query key on a cancel", "DELETE", "/api/v1/executions?key=execute-key", nil, http.StatusUnauthorized},
+		{"bearer token on a cancel", "DELETE", "/api/v1/executions", []string{"Authorization", "Bearer execute-key"}, http.StatusAccepted},
+		// Scopes do not include each other.
+		{"read without scope", "GET", "/api/v1/devices", []string{"Authorization", "Bearer execute-key"}, http.StatusForbidden},
+		{"read by manage", "GET", "/api/v1/devices", []string{"Authorization", "Bearer manage-key"}, http.StatusForbidden},
+		{"execute without scope", "POST", "/api/v1/devices/RollerShutters/close?key=read-key", nil, http.StatusForbidden},
+		{"manage without scope", "POST", "/api/v1/schedules/morning/pause", []string{"Authorization", "Bearer execute-key"}, http.StatusForbidden},
+		{"scoped gateway", "GET", "/api/v1/gateways/test/devices", []string{"Authorization", "Bearer manage-key"}, http.StatusForbidden},
+	}
+	for _, test := range tests {
+		response := serve(server, test.method, test.path, "", test.headers...)
+		if response.Code != test.expected {
+			t.Errorf("%s: expected %d, got %d %s", test.name, test.expected, response.Code, response.Body)
+		}
+	}
+}
+
+func TestClientCertificates(t *testing.T) {
+	dir := t.TempDir()
+	known, _ := x509.ParseCertificate(newTestClientCertificate(t, filepath.Join(dir, "known.crt"), "tablet").Certificate[0])
+	unknown, _ := x509.ParseCertificate(newTestClientCertificate(t, filepath.Join(dir, "unknown.crt"), "tablet").Certificate[0])
+	fingerprint := sha256.Sum256(known.Raw)
+	httpConfig := &config.Http{Tls: &config.Tls{
+		CertFile:   filepath.Join(dir, "adapter.crt"),
+		KeyFile:    filepath.Join(dir, "adapter.key"),
+		SelfSigned: true,
+		ClientCertificates: []*config.ClientCertificate{
+			{Name: "tablet", Fingerprint: hex.EncodeToString(fingerprint[:]), Scopes: []string{scopeRead}},
+		},
+	}}
+	serveCertificate := func(server *Server, certificate *x509.Certificate, headers ...string) int {
+		request := httptest.NewRequest("GET", "/api/v1/devices", nil)
+		request.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{certificate}}
+		for i := 0; i+1 < len(headers); i += 2 {
+			request.Header.Set(headers[i], headers[i+1])
+		}
+		response := httptest.NewRecorder()
+		server.server.Handler.ServeHTTP(response, request)
+		return response.Code
+	}
+
+	server := newTestServer(t, httpConfig, newTestGateway(t).Settings())
+	if status := serveCertificate(server, known); status != http.StatusOK {
+		t.Errorf("Expected access with a known certificate, got %d", status)
+	}
+	if status := serveCertificate(server, unknown); status != http.StatusForbidden {
+		t.Errorf("Expected an unknown certificate to be forbidden, got %d", status)
+	}
+
+	// With api keys a client with an unknown certificate falls back to an api key.
+	httpConfig.ApiKeys = []*config.ApiKey{{Name: "dashboard", Hash: HashApiKey("read-key"), Scopes: []string{scopeRead}}}
+	server = newTestServer(t, httpConfig, newTestGateway(t).Settings())
+	if status := serveCertificate(server, unknown); status != http.StatusUnauthorized {
+		t.Errorf("Expected an api key to be required, got %d", status)
+	}
+	if status := serveCertificate(server, unknown, "Authorization", "Bearer read-key"); status != http.StatusOK {
+		t.Errorf("Expected access with an api key, got %d", status)
+	}
+	if status := serveCertificate(server, known); status != http.StatusOK {
+		t.Errorf("Expected access with a known certificate, got %d", status)
+	}
+}
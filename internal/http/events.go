@@ -0,0 +1,248 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"overkiz-adapter/internal/domain"
+	"overkiz-adapter/internal/log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	eventBufferSize   = 64
+	keepAliveInterval = time.Second * 30
+	writeTimeout      = time.Second * 10
+)
+
+// eventMessage is a change of a device or execution that is pushed to the connected clients.
+type eventMessage struct {
+	Name      string            `json:"name"`
+	Gateway   string            `json:"gateway"`
+	DeviceURL string            `json:"device_url,omitempty"`
+	Label     string            `json:"label,omitempty"`
+	Class     string            `json:"class,omitempty"`
+	States    []*domain.State   `json:"states,omitempty"`
+	Execution *domain.Execution `json:"execution,omitempty"`
+	// devices are the devices the message is about, used to filter the messages per client.
+	devices []*domain.Device
+}
+
+// eventClient is a connected client that receives the messages that match its filter.
+type eventClient struct {
+	messages chan *eventMessage
+	gateway  string
+	class    string
+	label    string
+}
+
+// eventHub distributes the events of the gateways to all connected clients.
+type eventHub struct {
+	lock    sync.RWMutex
+	clients map[*eventClient]struct{}
+}
+
+func newEventHub(gateways []*domain.Overkiz) *eventHub {
+	hub := &eventHub{
+		clients: make(map[*eventClient]struct{}),
+	}
+	for _, gateway := range gateways {
+		overkiz := gateway
+		overkiz.AddEventListener(func(event *domain.Event) {
+			hub.publish(overkiz, event)
+		})
+	}
+	return hub
+}
+
+// subscribe registers a client that receives the messages of the given gateway, or of all gateways when no gateway is
+// given. The messages can be filtered with the class and label query parameters of the request.
+func (h *eventHub) subscribe(r *http.Request, gateway string) *eventClient {
+	client := &eventClient{
+		messages: make(chan *eventMessage, eventBufferSize),
+		gateway:  gateway,
+		class:    r.URL.Query().Get("class"),
+		label:    r.URL.Query().Get("label"),
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.clients[client] = struct{}{}
+	return client
+}
+
+func (h *eventHub) unsubscribe(client *eventClient) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	delete(h.clients, client)
+}
+
+func (h *eventHub) publish(overkiz *domain.Overkiz, event *domain.Event) {
+	message := newEventMessage(overkiz, event)
+	if message == nil {
+		return
+	}
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	for client := range h.clients {
+		if !client.matches(message) {
+			continue
+		}
+		select {
+		case client.messages <- message:
+		default:
+			log.Debug("Dropped event for slow client")
+		}
+	}
+}
+
+func newEventMessage(overkiz *domain.Overkiz, event *domain.Event) *eventMessage {
+	message := &eventMessage{
+		Name:    event.Name,
+		Gateway: overkiz.Name(),
+	}
+	switch event.Name {
+	case "DeviceStateChangedEvent", "DeviceCreatedEvent", "DeviceRemovedEvent":
+		message.DeviceURL = event.DeviceURL
+		message.States = event.DeviceStates
+		device := event.Device
+		if device == nil {
+			device = overkiz.Device(event.DeviceURL)
+		}
+		if device != nil {
+			message.Label = device.Label
+			message.Class = device.Class
+			message.devices = []*domain.Device{device}
+		}
+	case "ExecutionStateChangedEvent":
+		message.Execution = overkiz.TrackedExecution(event.ExecId)
+		if message.Execution == nil {
+			return nil
+		}
+		for _, deviceURL := range message.Execution.DeviceURLs {
+			device := overkiz.Device(deviceURL)
+			if device != nil {
+				message.devices = append(message.devices, device)
+			}
+		}
+	default:
+		return nil
+	}
+	return message
+}
+
+// matches returns true when the message is about a device that matches the gateway, class and label filter of the
+// client.
+func (c *eventClient) matches(message *eventMessage) bool {
+	if c.gateway != "" && c.gateway != message.Gateway {
+		return false
+	}
+	if c.class == "" && c.label == "" {
+		return true
+	}
+	for _, device := range message.devices {
+		if (c.class == "" || c.class == device.Class) && (c.label == "" || strings.EqualFold(c.label, device.Label)) {
+			return true
+		}
+	}
+	return false
+}
+
+// streamEvents streams the events as server-sent events until the client disconnects or the server shuts down.
+func (s *Server) streamEvents() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			writeError(w, r, http.StatusInternalServerError, "Streaming not supported")
+			return
+		}
+		client := s.events.subscribe(r, s.scopedGateway(r))
+		defer s.events.unsubscribe(client)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		keepAlive := time.NewTicker(keepAliveInterval)
+		defer keepAlive.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-s.shutdown:
+				return
+			case <-keepAlive.C:
+				_, err := fmt.Fprint(w, ": keep-alive\n\n")
+				if err != nil {
+					return
+				}
+			case message := <-client.messages:
+				data, err := json.Marshal(message)
+				if err != nil {
+					log.Errorf("Failed to marshal event: %v", err)
+					continue
+				}
+				_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", message.Name, data)
+				if err != nil {
+					return
+				}
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+var upgrader = websocket.Upgrader{}
+
+// websocketEvents sends the events as json messages over a websocket until the client disconnects or the server shuts
+// down.
+func (s *Server) websocketEvents() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// The upgrader already responded with an error.
+			log.Debugf("Failed to upgrade to websocket: %v", err)
+			return
+		}
+		defer func(conn *websocket.Conn) {
+			_ = conn.Close()
+		}(conn)
+		client := s.events.subscribe(r, s.scopedGateway(r))
+		defer s.events.unsubscribe(client)
+
+		// The client is not expected to send messages, reading is only needed to detect a closed connection.
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			for {
+				if _, _, err := conn.NextReader(); err != nil {
+					return
+				}
+			}
+		}()
+
+		keepAlive := time.NewTicker(keepAliveInterval)
+		defer keepAlive.Stop()
+		for {
+			select {
+			case <-closed:
+				return
+			case <-s.shutdown:
+				_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), time.Now().Add(writeTimeout))
+				return
+			case <-keepAlive.C:
+				err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeTimeout))
+			case message := <-client.messages:
+				_ = conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+				err = conn.WriteJSON(message)
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package http
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"overkiz-adapter/internal/config"
+	"overkiz-adapter/internal/domain"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventHub(t *testing.T) {
+	server := newTestServer(t, &config.Http{}, newTestGateway(t).Settings())
+	overkiz := server.gateways[0]
+	hub := newEventHub(nil)
+	all := hub.subscribe(httptest.NewRequest("GET", "/events", nil), "")
+	shutters := hub.subscribe(httptest.NewRequest("GET", "/events?class=RollerShutter", nil), "")
+	bedroom := hub.subscribe(httptest.NewRequest("GET", "/events?label=bedroom", nil), "")
+	other := hub.subscribe(httptest.NewRequest("GET", "/events", nil), "other")
+
+	hub.publish(overkiz, &domain.Event{Name: "DeviceStateChangedEvent", DeviceURL: "io://1234-5678-9012/1"})
+	hub.publish(overkiz, &domain.Event{Name: "DeviceStateChangedEvent", DeviceURL: "io://1234-5678-9012/2"})
+	hub.publish(overkiz, &domain.Event{Name: "EndUserLoginEvent"})
+	expected := map[*eventClient]int{all: 2, shutters: 1, bedroom: 1, other: 0}
+	for client, count := range expected {
+		if len(client.messages) != count {
+			t.Errorf("Expected %d messages for client %+v, got %d", count, client, len(client.messages))
+		}
+	}
+	message := <-shutters.messages
+	if message.Gateway != "test" || message.Label != "Bedroom" || message.Class != "RollerShutter" {
+		t.Errorf("Unexpected message %+v", message)
+	}
+
+	// A removed device is no longer known by the gateway, the event contains the device as it was before.
+	removed := &domain.Device{Label: "Kitchen", Class: "RollerShutter", DeviceURL: "io://1234-5678-9012/3"}
+	hub.publish(overkiz, &domain.Event{Name: "DeviceRemovedEvent", DeviceURL: removed.DeviceURL, Device: removed})
+	select {
+	case message = <-shutters.messages:
+		if message.Label != "Kitchen" || message.Class != "RollerShutter" {
+			t.Errorf("Unexpected message %+v", message)
+		}
+	default:
+		t.Error("Expected a message for the removed device")
+	}
+	if len(bedroom.messages) != 1 {
+		t.Error("Expected no message for the removed device when filtering on another label")
+	}
+
+	hub.unsubscribe(all)
+	hub.publish(overkiz, &domain.Event{Name: "DeviceStateChangedEvent", DeviceURL: "io://1234-5678-9012/1"})
+	if len(all.messages) != 3 {
+		t.Error("Expected no messages after unsubscribing")
+	}
+}
+
+func TestEventStreamShutdown(t *testing.T) {
+	server := newTestServer(t, &config.Http{}, newTestGateway(t).Settings())
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		_ = server.server.Serve(listener)
+	}()
+	response, err := http.Get("http://" + listener.Addr().String() + "/api/v1/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	err = server.Shutdown(ctx)
+	if err != nil {
+		t.Fatalf("Expected the event stream to end on shutdown, got %v", err)
+	}
+	_, err = bufio.NewReader(response.Body).ReadString('x')
+	if err == nil || strings.Contains(err.Error(), "timeout") {
+		t.Errorf("Expected the event stream to be closed, got %v", err)
+	}
+}
@@ -0,0 +1,117 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"net/http"
+	"overkiz-adapter/internal/domain"
+	"time"
+)
+
+type contextKey string
+
+const gatewayContextKey contextKey = "gateway"
+
+// gatewayContext is a middleware that resolves the gateway in the url of the request and stores it in the context of
+// the request, so all handlers below it are scoped to that gateway.
+func (s *Server) gatewayContext(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		name := chi.URLParam(r, "gateway")
+		for _, overkiz := range s.gateways {
+			if overkiz.Name() == name {
+				next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), gatewayContextKey, overkiz)))
+				return
+			}
+		}
+		writeError(w, r, http.StatusNotFound, fmt.Sprintf("Gateway %s not found", name))
+	}
+	return http.HandlerFunc(fn)
+}
+
+// scopedGateways returns the gateway the request is scoped to, or all gateways when the request is not scoped to a
+// gateway.
+func (s *Server) scopedGateways(r *http.Request) []*domain.Overkiz {
+	if overkiz, ok := r.Context().Value(gatewayContextKey).(*domain.Overkiz); ok {
+		return []*domain.Overkiz{overkiz}
+	}
+	return s.gateways
+}
+
+// scopedGateway returns the name of the gateway the request is scoped to, or an empty string when the request is not
+// scoped to a gateway.
+func (s *Server) scopedGateway(r *http.Request) string {
+	if overkiz, ok := r.Context().Value(gatewayContextKey).(*domain.Overkiz); ok {
+		return overkiz.Name()
+	}
+	return ""
+}
+
+// findExecution returns the execution with the given id together with the gateway that runs it. Executions that are
+// not tracked by the adapter are queried at every gateway the request is scoped to. When no gateway knows the execution
+// nil is returned, with the errors of the gateways that could not be queried.
+func (s *Server) findExecution(r *http.Request, execId string) (*domain.Overkiz, *domain.Execution, error) {
+	gateways := s.scopedGateways(r)
+	for _, overkiz := range gateways {
+		if overkiz.HasExecution(execId) {
+			execution, err := overkiz.Execution(execId)
+			return overkiz, execution, err
+		}
+	}
+	var errs []error
+	for _, overkiz := range gateways {
+		execution, err := overkiz.Execution(execId)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", overkiz.Name(), err))
+		} else if execution != nil {
+			return overkiz, execution, nil
+		}
+	}
+	return nil, nil, errors.Join(errs...)
+}
+
+// executeOnGateways executes the given function on every gateway the request is scoped to, and writes the ids of the
+// started executions. The function returns a nil execution for a gateway without devices to execute on.
+func (s *Server) executeOnGateways(w http.ResponseWriter, r *http.Request, notFoundMessage string, execute func(overkiz *domain.Overkiz) (*domain.Execution, error)) {
+	executions := make([]*domain.Execution, 0)
+	var errs []error
+	for _, overkiz := range s.scopedGateways(r) {
+		execution, err := execute(overkiz)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", overkiz.Name(), err))
+		} else if execution != nil {
+			executions = append(executions, execution)
+		}
+	}
+	writeExecutionsResponse(w, r, executions, errors.Join(errs...), notFoundMessage)
+}
+
+type gatewayResponse struct {
+	Name        string     `json:"name"`
+	Devices     int        `json:"devices"`
+	LastRefresh *time.Time `json:"last_refresh,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+}
+
+func (s *Server) getGateways() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		gateways := make([]*gatewayResponse, 0, len(s.gateways))
+		for _, overkiz := range s.gateways {
+			gateway := &gatewayResponse{
+				Name:    overkiz.Name(),
+				Devices: len(overkiz.Devices("")),
+			}
+			lastRefresh, lastError := overkiz.RefreshStatus()
+			if !lastRefresh.IsZero() {
+				gateway.LastRefresh = &lastRefresh
+			}
+			if lastError != nil {
+				gateway.LastError = lastError.Error()
+			}
+			gateways = append(gateways, gateway)
+		}
+		render.JSON(w, r, gateways)
+	}
+}
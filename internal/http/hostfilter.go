@@ -1,39 +1,177 @@
 package http
 
 import (
+	"fmt"
 	"net"
 	"net/http"
 	"overkiz-adapter/internal/log"
 	"strings"
 )
 
-func HostFilter(hosts ...string) func(next http.Handler) http.Handler {
-	allowedHosts := make(map[string]struct{}, len(hosts))
+// hostList is a list of ip addresses, ip ranges in CIDR notation and domain names.
+type hostList struct {
+	networks []*net.IPNet
+	names    map[string]struct{}
+}
+
+func newHostList(hosts []string) (*hostList, error) {
+	list := &hostList{
+		names: make(map[string]struct{}),
+	}
 	for _, host := range hosts {
-		allowedHosts[strings.TrimSpace(strings.ToLower(host))] = struct{}{}
+		host = strings.TrimSpace(strings.ToLower(host))
+		if host == "" {
+			continue
+		}
+		network, err := parseNetwork(host)
+		if err != nil {
+			return nil, err
+		}
+		if network != nil {
+			list.networks = append(list.networks, network)
+		} else {
+			list.names[host] = struct{}{}
+		}
+	}
+	return list, nil
+}
+
+// parseNetwork parses an ip address or an ip range in CIDR notation. Nil is returned when the host is a domain name.
+func parseNetwork(host string) (*net.IPNet, error) {
+	if strings.Contains(host, "/") {
+		_, network, err := net.ParseCIDR(host)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ip range %s: %w", host, err)
+		}
+		return network, nil
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, nil
+	}
+	bits := 8 * net.IPv6len
+	if ip.To4() != nil {
+		ip = ip.To4()
+		bits = 8 * net.IPv4len
 	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
+
+func (l *hostList) empty() bool {
+	return len(l.networks) == 0 && len(l.names) == 0
+}
+
+func (l *hostList) containsIP(ip net.IP) bool {
+	for _, network := range l.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *hostList) containsName(names []string) bool {
+	for _, name := range names {
+		if _, ok := l.names[strings.TrimSuffix(strings.ToLower(name), ".")]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// HostFilter only passes requests of clients that are in the list of allowed hosts and not in the list of denied hosts.
+// Hosts are ip addresses, ip ranges in CIDR notation like 192.168.1.0/24 or fd00::/8, or domain names that are matched
+// with the reverse DNS names of the client. When there are no allowed hosts all clients that are not denied are
+// allowed. The reverse DNS names are looked up with the resolver, and only when the ip address of the client does not
+// decide the outcome by itself.
+func HostFilter(allowed []string, denied []string, resolver *Resolver) (func(next http.Handler) http.Handler, error) {
+	allowedHosts, err := newHostList(allowed)
+	if err != nil {
+		return nil, err
+	}
+	deniedHosts, err := newHostList(denied)
+	if err != nil {
+		return nil, err
+	}
+	lookupNames := len(allowedHosts.names) > 0 || len(deniedHosts.names) > 0
 	return func(next http.Handler) http.Handler {
 		fn := func(w http.ResponseWriter, r *http.Request) {
-			addresses := make([]string, 0)
 			host, _, err := net.SplitHostPort(r.RemoteAddr)
 			if err != nil {
 				host = r.RemoteAddr
 			}
-			addresses = append(addresses, host)
-			lookupHosts, err := net.LookupAddr(host)
-			if err == nil {
-				addresses = append(addresses, lookupHosts...)
+			ip := net.ParseIP(host)
+			allowed := !deniedHosts.containsIP(ip) && (allowedHosts.empty() || allowedHosts.containsIP(ip))
+			// A client that is allowed by its ip address only needs to be looked up when names are denied, a client
+			// that is not allowed by its ip address only when names are allowed.
+			var names []string
+			if allowed && len(deniedHosts.names) > 0 || !allowed && !deniedHosts.containsIP(ip) && len(allowedHosts.names) > 0 {
+				names = resolver.LookupAddr(r.Context(), host)
+				allowed = (allowed || allowedHosts.containsName(names)) && !deniedHosts.containsName(names)
+			} else if lookupNames {
+				resolver.skip()
+			}
+			if !allowed {
+				log.Infof("Access forbidden for %s %v", host, names)
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}, nil
+}
+
+// TrustedProxies replaces the remote address of requests that are forwarded by one of the trusted proxies with the
+// address of the client in the X-Forwarded-For or X-Real-IP header. The X-Forwarded-For header is read from right to
+// left, skipping the addresses of trusted proxies, so addresses that a client added itself are never used. Headers of
+// requests that do not come from a trusted proxy are ignored.
+func TrustedProxies(proxies []string) (func(next http.Handler) http.Handler, error) {
+	trustedProxies, err := newHostList(proxies)
+	if err != nil {
+		return nil, err
+	}
+	if len(trustedProxies.names) > 0 {
+		return nil, fmt.Errorf("trusted proxies must be ip addresses or ip ranges")
+	}
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			host, _, err := net.SplitHostPort(r.RemoteAddr)
+			if err != nil {
+				host = r.RemoteAddr
 			}
-			for _, address := range addresses {
-				if _, ok := allowedHosts[strings.TrimSpace(strings.ToLower(address))]; ok {
-					next.ServeHTTP(w, r)
-					return
+			if ip := net.ParseIP(host); ip != nil && trustedProxies.containsIP(ip) {
+				if client := forwardedFor(r, trustedProxies); client != nil {
+					r.RemoteAddr = client.String()
 				}
 			}
-			log.Infof("Access forbidden for %v", addresses)
-			w.WriteHeader(http.StatusForbidden)
-			return
+			next.ServeHTTP(w, r)
 		}
 		return http.HandlerFunc(fn)
+	}, nil
+}
+
+// forwardedFor returns the address of the client that the trusted proxies forwarded the request for, or nil when the
+// proxies did not send a valid address.
+func forwardedFor(r *http.Request, trustedProxies *hostList) net.IP {
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	if len(hops) == 0 {
+		return net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP")))
+	}
+	var client net.IP
+	for ix := len(hops) - 1; ix >= 0; ix-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[ix]))
+		if ip == nil {
+			// Everything left of an invalid address cannot be trusted, the last valid hop is the client.
+			break
+		}
+		client = ip
+		if !trustedProxies.containsIP(ip) {
+			break
+		}
 	}
+	return client
 }
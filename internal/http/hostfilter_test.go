@@ -0,0 +1,95 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTrustedProxies(t *testing.T) {
+	proxies, err := TrustedProxies([]string{"10.0.0.1", "172.16.0.0/12"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		expected   string
+	}{
+		{"direct client", "192.168.1.10:5000", nil, "192.168.1.10:5000"},
+		{"spoofed header from untrusted client", "192.168.1.66:5000", map[string]string{"X-Forwarded-For": "192.168.1.10"}, "192.168.1.66:5000"},
+		{"spoofed real ip from untrusted client", "192.168.1.66:5000", map[string]string{"X-Real-IP": "192.168.1.10"}, "192.168.1.66:5000"},
+		{"trusted proxy", "10.0.0.1:5000", map[string]string{"X-Forwarded-For": "192.168.1.10"}, "192.168.1.10"},
+		{"trusted proxy with real ip", "10.0.0.1:5000", map[string]string{"X-Real-IP": "192.168.1.10"}, "192.168.1.10"},
+		{"spoofed entry behind trusted proxy", "10.0.0.1:5000", map[string]string{"X-Forwarded-For": "192.168.1.10, 192.168.1.66"}, "192.168.1.66"},
+		{"chain of trusted proxies", "10.0.0.1:5000", map[string]string{"X-Forwarded-For": "192.168.1.66, 172.16.5.4"}, "192.168.1.66"},
+		{"invalid entry behind trusted proxy", "10.0.0.1:5000", map[string]string{"X-Forwarded-For": "192.168.1.10, garbage, 172.16.5.4"}, "172.16.5.4"},
+		{"trusted proxy without header", "10.0.0.1:5000", nil, "10.0.0.1:5000"},
+	}
+	for _, test := range tests {
+		var remoteAddr string
+		handler := proxies(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			remoteAddr = r.RemoteAddr
+		}))
+		request := httptest.NewRequest("GET", "/", nil)
+		request.RemoteAddr = test.remoteAddr
+		for name, value := range test.headers {
+			request.Header.Set(name, value)
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), request)
+		if remoteAddr != test.expected {
+			t.Errorf("%s: expected remote address %s, got %s", test.name, test.expected, remoteAddr)
+		}
+	}
+}
+
+func TestHostFilter(t *testing.T) {
+	proxies, err := TrustedProxies([]string{"10.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter, err := HostFilter([]string{"192.168.1.0/24", "fd00::/8", "127.0.0.1"}, []string{"192.168.1.66"}, NewResolver(time.Minute, time.Minute, time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := proxies(filter(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	tests := []struct {
+		name           string
+		remoteAddr     string
+		forwardedFor   string
+		expectedStatus int
+	}{
+		{"allowed range", "192.168.1.10:5000", "", http.StatusOK},
+		{"allowed address", "127.0.0.1:5000", "", http.StatusOK},
+		{"allowed ipv6 prefix", "[fd12:3456::1]:5000", "", http.StatusOK},
+		{"outside range", "192.168.2.10:5000", "", http.StatusForbidden},
+		{"denied address", "192.168.1.66:5000", "", http.StatusForbidden},
+		{"spoofed header from outside range", "192.168.2.10:5000", "192.168.1.10", http.StatusForbidden},
+		{"forwarded by trusted proxy", "10.0.0.1:5000", "192.168.1.10", http.StatusOK},
+		{"denied address forwarded by trusted proxy", "10.0.0.1:5000", "192.168.1.66", http.StatusForbidden},
+		{"spoofed allowed address forwarded by trusted proxy", "10.0.0.1:5000", "192.168.1.10, 8.8.8.8", http.StatusForbidden},
+	}
+	for _, test := range tests {
+		request := httptest.NewRequest("GET", "/", nil)
+		request.RemoteAddr = test.remoteAddr
+		if test.forwardedFor != "" {
+			request.Header.Set("X-Forwarded-For", test.forwardedFor)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		if recorder.Code != test.expectedStatus {
+			t.Errorf("%s: expected status %d, got %d", test.name, test.expectedStatus, recorder.Code)
+		}
+	}
+}
+
+func TestInvalidHosts(t *testing.T) {
+	if _, err := HostFilter([]string{"192.168.1.0/33"}, nil, nil); err == nil {
+		t.Error("Expected an error for an invalid ip range")
+	}
+	if _, err := TrustedProxies([]string{"proxy.local"}); err == nil {
+		t.Error("Expected an error for a trusted proxy name")
+	}
+}
@@ -0,0 +1,144 @@
+package http
+
+import (
+	"context"
+	"golang.org/x/sync/singleflight"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxResolverEntries is the maximum number of cached addresses. When the cache is full the expired entries are removed,
+// or else the entry that expires first.
+const maxResolverEntries = 1024
+
+// Resolver looks up the reverse DNS names of ip addresses and caches them. Failed lookups and addresses without names
+// are cached as well, for a shorter time, so an unavailable DNS server does not slow down every request.
+type Resolver struct {
+	lookup      func(ctx context.Context, address string) ([]string, error)
+	ttl         time.Duration
+	negativeTtl time.Duration
+	timeout     time.Duration
+	lock        sync.Mutex
+	entries     map[string]*resolverEntry
+	group       singleflight.Group
+	stats       resolverCounters
+}
+
+type resolverEntry struct {
+	names   []string
+	expires time.Time
+}
+
+type resolverCounters struct {
+	hits         atomic.Uint64
+	negativeHits atomic.Uint64
+	misses       atomic.Uint64
+	failures     atomic.Uint64
+	skipped      atomic.Uint64
+}
+
+// ResolverStats are the counters of the resolver since the start of the application.
+type ResolverStats struct {
+	// Hits are the lookups that were answered with cached names.
+	Hits uint64 `json:"hits"`
+	// NegativeHits are the lookups that were answered with a cached failure or absence of names.
+	NegativeHits uint64 `json:"negative_hits"`
+	// Misses are the lookups that were sent to the DNS server.
+	Misses uint64 `json:"misses"`
+	// Failures are the lookups of the DNS server that failed or timed out.
+	Failures uint64 `json:"failures"`
+	// Skipped are the requests that did not need a lookup, because their ip address was sufficient.
+	Skipped uint64 `json:"skipped"`
+	Entries int    `json:"entries"`
+}
+
+func NewResolver(ttl time.Duration, negativeTtl time.Duration, timeout time.Duration) *Resolver {
+	return &Resolver{
+		lookup:      net.DefaultResolver.LookupAddr,
+		ttl:         ttl,
+		negativeTtl: negativeTtl,
+		timeout:     timeout,
+		entries:     make(map[string]*resolverEntry),
+	}
+}
+
+// LookupAddr returns the reverse DNS names of the address, or nil when the address has no names, the lookup failed or
+// the context is done before the lookup finished.
+func (r *Resolver) LookupAddr(ctx context.Context, address string) []string {
+	r.lock.Lock()
+	entry, ok := r.entries[address]
+	r.lock.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		if len(entry.names) == 0 {
+			r.stats.negativeHits.Add(1)
+		} else {
+			r.stats.hits.Add(1)
+		}
+		return entry.names
+	}
+	// Concurrent requests of the same client share a single lookup. The lookup is not bound to the request that started
+	// it, otherwise a cancelled request would fail the lookup for the other requests and cache the failure.
+	result := r.group.DoChan(address, func() (any, error) {
+		r.stats.misses.Add(1)
+		ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+		defer cancel()
+		names, err := r.lookup(ctx, address)
+		ttl := r.ttl
+		if err != nil || len(names) == 0 {
+			if err != nil {
+				r.stats.failures.Add(1)
+			}
+			names = nil
+			ttl = r.negativeTtl
+		}
+		r.store(address, &resolverEntry{names: names, expires: time.Now().Add(ttl)})
+		return names, nil
+	})
+	select {
+	case <-ctx.Done():
+		return nil
+	case lookup := <-result:
+		return lookup.Val.([]string)
+	}
+}
+
+func (r *Resolver) store(address string, entry *resolverEntry) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if _, ok := r.entries[address]; !ok && len(r.entries) >= maxResolverEntries {
+		now := time.Now()
+		first := ""
+		for key, existing := range r.entries {
+			if now.After(existing.expires) {
+				delete(r.entries, key)
+			} else if first == "" || existing.expires.Before(r.entries[first].expires) {
+				first = key
+			}
+		}
+		if len(r.entries) >= maxResolverEntries {
+			delete(r.entries, first)
+		}
+	}
+	r.entries[address] = entry
+}
+
+// skip records a request that did not need a lookup.
+func (r *Resolver) skip() {
+	r.stats.skipped.Add(1)
+}
+
+func (r *Resolver) Stats() *ResolverStats {
+	r.lock.Lock()
+	entries := len(r.entries)
+	r.lock.Unlock()
+	return &ResolverStats{
+		Hits:         r.stats.hits.Load(),
+		NegativeHits: r.stats.negativeHits.Load(),
+		Misses:       r.stats.misses.Load(),
+		Failures:     r.stats.failures.Load(),
+		Skipped:      r.stats.skipped.Load(),
+		Entries:      entries,
+	}
+}
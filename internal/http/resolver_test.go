@@ -0,0 +1,143 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fakeResolver creates a resolver that answers lookups from the given names and counts the lookups per address.
+func fakeResolver(ttl time.Duration, names map[string][]string, lookups map[string]int) *Resolver {
+	resolver := NewResolver(ttl, ttl, time.Second)
+	resolver.lookup = func(ctx context.Context, address string) ([]string, error) {
+		lookups[address]++
+		if result, ok := names[address]; ok {
+			return result, nil
+		}
+		return nil, errors.New("no such host")
+	}
+	return resolver
+}
+
+func TestResolverCache(t *testing.T) {
+	lookups := make(map[string]int)
+	resolver := fakeResolver(time.Minute, map[string][]string{"192.168.1.10": {"laptop.home."}}, lookups)
+	for ix := 0; ix < 3; ix++ {
+		names := resolver.LookupAddr(context.Background(), "192.168.1.10")
+		if len(names) != 1 || names[0] != "laptop.home." {
+			t.Fatalf("Expected laptop.home., got %v", names)
+		}
+		if names := resolver.LookupAddr(context.Background(), "192.168.1.20"); names != nil {
+			t.Fatalf("Expected no names, got %v", names)
+		}
+	}
+	if lookups["192.168.1.10"] != 1 || lookups["192.168.1.20"] != 1 {
+		t.Errorf("Expected a single lookup per address, got %v", lookups)
+	}
+	stats := resolver.Stats()
+	if stats.Hits != 2 || stats.NegativeHits != 2 || stats.Misses != 2 || stats.Failures != 1 || stats.Entries != 2 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestResolverExpiry(t *testing.T) {
+	lookups := make(map[string]int)
+	resolver := fakeResolver(time.Millisecond, map[string][]string{"192.168.1.10": {"laptop.home."}}, lookups)
+	resolver.LookupAddr(context.Background(), "192.168.1.10")
+	time.Sleep(5 * time.Millisecond)
+	resolver.LookupAddr(context.Background(), "192.168.1.10")
+	if lookups["192.168.1.10"] != 2 {
+		t.Errorf("Expected the expired entry to be looked up again, got %d lookups", lookups["192.168.1.10"])
+	}
+}
+
+func TestHostFilterLookups(t *testing.T) {
+	lookups := make(map[string]int)
+	names := map[string][]string{
+		"192.168.2.10": {"laptop.home."},
+		"192.168.2.66": {"guest.home."},
+	}
+	resolver := fakeResolver(time.Minute, names, lookups)
+	filter, err := HostFilter([]string{"192.168.1.0/24", "laptop.home"}, []string{"192.168.1.66"}, resolver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := filter(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	tests := []struct {
+		remoteAddr     string
+		expectedStatus int
+		expectedLookup bool
+	}{
+		{"192.168.1.10:5000", http.StatusOK, false},
+		{"192.168.1.66:5000", http.StatusForbidden, false},
+		{"192.168.2.10:5000", http.StatusOK, true},
+		{"192.168.2.66:5000", http.StatusForbidden, true},
+	}
+	for _, test := range tests {
+		request := httptest.NewRequest("GET", "/", nil)
+		request.RemoteAddr = test.remoteAddr
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		if recorder.Code != test.expectedStatus {
+			t.Errorf("%s: expected status %d, got %d", test.remoteAddr, test.expectedStatus, recorder.Code)
+		}
+		host, _, _ := net.SplitHostPort(test.remoteAddr)
+		if looked := lookups[host] > 0; looked != test.expectedLookup {
+			t.Errorf("%s: expected lookup %t, got %t", test.remoteAddr, test.expectedLookup, looked)
+		}
+	}
+	if stats := resolver.Stats(); stats.Skipped != 2 {
+		t.Errorf("Expected 2 skipped lookups, got %d", stats.Skipped)
+	}
+}
+
+func TestResolverCancelledRequest(t *testing.T) {
+	resolver := NewResolver(time.Minute, time.Minute, time.Second)
+	started, release := make(chan struct{}), make(chan struct{})
+	resolver.lookup = func(ctx context.Context, address string) ([]string, error) {
+		close(started)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-release:
+			return []string{"laptop.home."}, nil
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan []string)
+	go func() {
+		done <- resolver.LookupAddr(ctx, "192.168.1.10")
+	}()
+	<-started
+	cancel()
+	if names := <-done; names != nil {
+		t.Errorf("Expected no names for a cancelled request, got %v", names)
+	}
+	// The lookup continues for the other requests and is cached.
+	close(release)
+	names := resolver.LookupAddr(context.Background(), "192.168.1.10")
+	if len(names) != 1 || names[0] != "laptop.home." {
+		t.Errorf("Expected laptop.home., got %v", names)
+	}
+}
+
+func TestResolverLimit(t *testing.T) {
+	lookups := make(map[string]int)
+	resolver := fakeResolver(time.Minute, map[string][]string{}, lookups)
+	for ix := 0; ix < maxResolverEntries+10; ix++ {
+		resolver.LookupAddr(context.Background(), fmt.Sprintf("10.0.%d.%d", ix/256, ix%256))
+	}
+	if entries := resolver.Stats().Entries; entries != maxResolverEntries {
+		t.Errorf("Expected %d entries, got %d", maxResolverEntries, entries)
+	}
+	// The entries that expire first are removed.
+	resolver.LookupAddr(context.Background(), "10.0.0.0")
+	if lookups["10.0.0.0"] != 2 || lookups["10.0.4.9"] != 1 {
+		t.Errorf("Expected the oldest entry to be removed, got %d lookups", lookups["10.0.0.0"])
+	}
+}
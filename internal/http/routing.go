@@ -2,27 +2,55 @@ package http
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/render"
+	"io"
 	"net/http"
+	"net/url"
 	"overkiz-adapter/internal/config"
 	"overkiz-adapter/internal/domain"
 	"overkiz-adapter/internal/log"
+	"overkiz-adapter/internal/rules"
+	"overkiz-adapter/internal/schedule"
 	"strconv"
 	"strings"
 	"time"
 )
 
 type Server struct {
-	server  *http.Server
-	overkiz *domain.Overkiz
+	server    *http.Server
+	gateways  []*domain.Overkiz
+	events    *eventHub
+	rules     *rules.Engine
+	scheduler *schedule.Scheduler
+	// resolver looks up the reverse DNS names for the host filter, or is nil when no hosts are filtered.
+	resolver *Resolver
+	// certificates is the certificate of the https server, or nil when the server uses http.
+	certificates *certificateStore
+	// allowGetActions registers the actions for GET requests as well.
+	allowGetActions bool
+	// authenticated is true when clients must authenticate, and the scopes of the routes are enforced.
+	authenticated bool
+	// shutdown is closed when the server shuts down, to end the event streams that would otherwise keep it running.
+	shutdown chan struct{}
 }
 
-func NewServer(config *config.Http, overkiz *domain.Overkiz) (*Server, error) {
+func NewServer(config *config.Http, rules *rules.Engine, scheduler *schedule.Scheduler, gateways ...*domain.Overkiz) (*Server, error) {
+	if len(gateways) == 0 {
+		return nil, errors.New("no gateways configured")
+	}
+	clientCertificates := config.ClientCertificates()
 	s := &Server{
-		overkiz: overkiz,
+		gateways:        gateways,
+		events:          newEventHub(gateways),
+		rules:           rules,
+		scheduler:       scheduler,
+		allowGetActions: config.AllowGetActions,
+		authenticated:   len(config.ApiKeys) > 0 || len(clientCertificates) > 0,
+		shutdown:        make(chan struct{}),
 	}
 
 	contextRoot := config.ContextRoot
@@ -38,11 +66,25 @@ func NewServer(config *config.Http, overkiz *domain.Overkiz) (*Server, error) {
 	}
 
 	r := chi.NewRouter()
-	if config.BehindProxy {
-		r.Use(middleware.RealIP)
+	trustedProxies := config.TrustedProxies
+	if config.BehindProxy && len(trustedProxies) == 0 {
+		// Without explicitly trusted proxies only a proxy on the same host is trusted.
+		trustedProxies = []string{"127.0.0.0/8", "::1"}
 	}
-	if len(config.AllowedHosts) > 0 {
-		r.Use(HostFilter(config.AllowedHosts...))
+	if len(trustedProxies) > 0 {
+		proxies, err := TrustedProxies(trustedProxies)
+		if err != nil {
+			return nil, err
+		}
+		r.Use(proxies)
+	}
+	if len(config.AllowedHosts) > 0 || len(config.DeniedHosts) > 0 {
+		s.resolver = NewResolver(config.DnsCacheTtl.Duration, config.DnsNegativeTtl.Duration, config.DnsTimeout.Duration)
+		hostFilter, err := HostFilter(config.AllowedHosts, config.DeniedHosts, s.resolver)
+		if err != nil {
+			return nil, err
+		}
+		r.Use(hostFilter)
 	}
 	r.Use(
 		render.SetContentType(render.ContentTypeJSON),
@@ -50,16 +92,33 @@ func NewServer(config *config.Http, overkiz *domain.Overkiz) (*Server, error) {
 		middleware.RequestID,
 		middleware.RedirectSlashes,
 		middleware.Recoverer,
-		middleware.Timeout(60*time.Second),
 	)
+	if s.authenticated {
+		r.Use(authenticate(config.ApiKeys, clientCertificates))
+	}
+	r.Use(logIdentity)
+	r.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+	})
 	r.Route(contextRoot, func(r chi.Router) {
 		r.Route("/api/v1", func(r chi.Router) {
-			r.Get("/devices", s.getDevices())
-			r.Get("/devices/{class}", s.getDevices())
-			r.Get("/devices/RollerShutters/close", s.rollerShutter("close"))
-			r.Get("/devices/RollerShutters/close/{percentage}", s.rollerShutter("close"))
-			r.Get("/devices/RollerShutters/open", s.rollerShutter("open"))
-			r.Get("/devices/RollerShutters/open/{percentage}", s.rollerShutter("open"))
+			s.routes(r)
+			read := r.With(s.requireScope(scopeRead))
+			read.Get("/gateways", s.getGateways())
+			read.Get("/rules", s.getRules())
+			read.Get("/schedules", s.getSchedules())
+			read.Get("/metrics", s.getMetrics())
+			manage := r.With(s.requireScope(scopeManage))
+			manage.Post("/rules/{rule}/enable", s.enableRule(true))
+			manage.Post("/rules/{rule}/disable", s.enableRule(false))
+			manage.Post("/schedules/{schedule}/pause", s.pauseSchedule(true))
+			manage.Post("/schedules/{schedule}/resume", s.pauseSchedule(false))
+			manage.Post("/schedules/{schedule}/skip", s.skipSchedule())
+			s.action(r.With(s.requireActionScope(scopeExecute)), "/hooks/{webhook}", s.triggerWebhook())
+			r.Route("/gateways/{gateway}", func(r chi.Router) {
+				r.Use(s.gatewayContext)
+				s.routes(r)
+			})
 		})
 	})
 
@@ -67,65 +126,502 @@ func NewServer(config *config.Http, overkiz *domain.Overkiz) (*Server, error) {
 		Addr:    fmt.Sprintf("%s:%d", config.Interface, config.Port),
 		Handler: r,
 	}
+	s.server.RegisterOnShutdown(func() {
+		close(s.shutdown)
+	})
+	if config.Tls != nil {
+		tlsConfig, certificates, err := newTlsConfig(config.Tls, len(config.ApiKeys) > 0)
+		if err != nil {
+			return nil, err
+		}
+		s.server.TLSConfig = tlsConfig
+		s.certificates = certificates
+	}
 	return s, nil
 }
 
+// routes registers the api routes. The routes are registered for all gateways and for every gateway separately.
+func (s *Server) routes(r chi.Router) {
+	// The event streams are long-running, so they are not subject to the request timeout.
+	r.With(s.requireScope(scopeRead)).Get("/events", s.streamEvents())
+	r.With(s.requireScope(scopeRead)).Get("/events/ws", s.websocketEvents())
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.Timeout(60 * time.Second))
+		read := r.With(s.requireScope(scopeRead))
+		read.Get("/devices", s.getDevices())
+		read.Get("/devices/{class}", s.getDevices())
+		read.Get("/devices/{class}/{device}/states", s.getDeviceStates())
+		read.Get("/executions/{execId}", s.getExecution())
+		read.Get("/scenarios", s.getScenarios())
+		read.Get("/scenes", s.getScenes())
+
+		execute := r.With(s.requireScope(scopeExecute))
+		execute.Post("/devices/refresh", s.refreshDevices())
+		execute.Delete("/executions", s.cancelExecutions())
+		execute.Delete("/executions/{execId}", s.cancelExecution())
+		action := r.With(s.requireActionScope(scopeExecute))
+		s.action(action, "/devices/{class}/commands", s.executeCommand())
+		s.action(action, "/device/{device}/commands", s.executeDeviceCommand())
+		s.action(action, "/device/{device}/close", s.device("close"))
+		s.action(action, "/device/{device}/close/{percentage}", s.device("close"))
+		s.action(action, "/device/{device}/open", s.device("open"))
+		s.action(action, "/device/{device}/open/{percentage}", s.device("open"))
+		s.action(action, "/devices/RollerShutters/close", s.rollerShutter("close"))
+		s.action(action, "/devices/RollerShutters/close/{percentage}", s.rollerShutter("close"))
+		s.action(action, "/devices/RollerShutters/open", s.rollerShutter("open"))
+		s.action(action, "/devices/RollerShutters/open/{percentage}", s.rollerShutter("open"))
+		s.action(action, "/devices/{class}/stop", s.stop())
+		s.action(action, "/scenarios/{scenario}/execute", s.executeScenario())
+		s.action(action, "/scenes/{scene}", s.executeScene())
+	})
+}
+
+// action registers a route that executes an action for POST and PUT requests. Because browsers, link prefetchers and
+// crawlers follow links, GET requests are only accepted when allowed in the configuration.
+func (s *Server) action(r chi.Router, pattern string, handler http.HandlerFunc) {
+	r.Post(pattern, handler)
+	r.Put(pattern, handler)
+	if s.allowGetActions {
+		r.Get(pattern, handler)
+	}
+}
+
 func (s *Server) Start() error {
+	if s.certificates != nil {
+		log.Infof("Starting https server at %v", s.server.Addr)
+		return s.server.ListenAndServeTLS("", "")
+	}
 	log.Infof("Starting http server at %v", s.server.Addr)
 	return s.server.ListenAndServe()
 }
 
+// ReloadCertificate reloads the certificate and client CAs of the https server from their files. New connections use the
+// reloaded certificate and client CAs. When they cannot be loaded, the current ones are kept.
+func (s *Server) ReloadCertificate() error {
+	if s.certificates == nil {
+		return nil
+	}
+	err := s.certificates.load()
+	if err != nil {
+		return err
+	}
+	log.Infof("Reloaded certificate %s", s.certificates.certFile)
+	return nil
+}
+
 func (s *Server) Shutdown(ctx context.Context) error {
 	log.Info("Shutting down http server")
 	return s.server.Shutdown(ctx)
 }
 
+type metricsResponse struct {
+	ReverseDns *ResolverStats `json:"reverse_dns,omitempty"`
+}
+
+func (s *Server) getMetrics() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		response := &metricsResponse{}
+		if s.resolver != nil {
+			response.ReverseDns = s.resolver.Stats()
+		}
+		render.JSON(w, r, response)
+	}
+}
+
+const (
+	lastRefreshHeader = "X-Last-Refresh"
+	lastErrorHeader   = "X-Last-Error"
+)
+
 func (s *Server) getDevices() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		class := chi.URLParam(r, "class")
-		render.JSON(w, r, s.overkiz.Devices(class))
+		class := s.deviceClass(r, chi.URLParam(r, "class"))
+		render.JSON(w, r, s.listDevices(w, r, class))
+	}
+}
+
+func (s *Server) refreshDevices() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		failed := false
+		for _, overkiz := range s.scopedGateways(r) {
+			err := overkiz.RefreshDevices()
+			if err != nil {
+				log.Errorf("Failed to refresh devices of gateway %s: %v", overkiz.Name(), err)
+				failed = true
+			}
+		}
+		if failed {
+			render.Status(r, http.StatusBadGateway)
+		}
+		render.JSON(w, r, s.listDevices(w, r, ""))
+	}
+}
+
+// listDevices returns the devices of the gateways in scope of the request. The oldest successful refresh of those
+// gateways and the errors of their last refresh are reported in the response headers.
+func (s *Server) listDevices(w http.ResponseWriter, r *http.Request, class string) []*domain.Device {
+	devices := make([]*domain.Device, 0)
+	var oldestRefresh time.Time
+	var errs []string
+	for i, overkiz := range s.scopedGateways(r) {
+		devices = append(devices, overkiz.Devices(class)...)
+		lastRefresh, lastError := overkiz.RefreshStatus()
+		if i == 0 || lastRefresh.Before(oldestRefresh) {
+			oldestRefresh = lastRefresh
+		}
+		if lastError != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", overkiz.Name(), lastError))
+		}
+	}
+	if !oldestRefresh.IsZero() {
+		w.Header().Set(lastRefreshHeader, oldestRefresh.Format(time.RFC3339))
+	}
+	if len(errs) > 0 {
+		w.Header().Set(lastErrorHeader, strings.Join(errs, ", "))
+	}
+	return devices
+}
+
+func (s *Server) getDeviceStates() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		overkiz, device, ok := s.findDevice(w, r, s.deviceClass(r, chi.URLParam(r, "class")))
+		if !ok {
+			return
+		}
+		states, err := overkiz.DeviceStates(device)
+		if err != nil {
+			log.Errorf("Failed to retrieve device states: %v", err)
+			writeError(w, r, http.StatusBadGateway, err.Error())
+			return
+		}
+		render.JSON(w, r, states)
+	}
+}
+
+type commandRequest struct {
+	Name       string `json:"name"`
+	Parameters []any  `json:"parameters"`
+}
+
+func (s *Server) executeCommand() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		request, ok := decodeCommandRequest(w, r)
+		if !ok {
+			return
+		}
+		class := s.deviceClass(r, chi.URLParam(r, "class"))
+		s.executeOnGateways(w, r, fmt.Sprintf("No %s devices found", class), func(overkiz *domain.Overkiz) (*domain.Execution, error) {
+			return overkiz.ExecuteCommand(class, request.Name, request.Parameters)
+		})
+	}
+}
+
+func (s *Server) executeDeviceCommand() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		request, ok := decodeCommandRequest(w, r)
+		if !ok {
+			return
+		}
+		overkiz, device, ok := s.findDevice(w, r, "")
+		if !ok {
+			return
+		}
+		execution, err := overkiz.ExecuteDeviceCommand(device, request.Name, request.Parameters)
+		writeExecutionResponse(w, r, execution, err, "")
+	}
+}
+
+func (s *Server) device(actionName string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		action, parameters, err := openCloseAction(actionName, r)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "Invalid percentage")
+			return
+		}
+		overkiz, device, ok := s.findDevice(w, r, "")
+		if !ok {
+			return
+		}
+		execution, err := overkiz.ExecuteDeviceCommand(device, action, parameters)
+		writeExecutionResponse(w, r, execution, err, "")
+	}
+}
+
+// findDevice looks up the device of the given class, or of any class when the class is empty, that is addressed by the
+// url encoded deviceURL or label in the device url parameter, together with the gateway it is connected to. When the
+// device cannot be found an error response is written and false is returned.
+func (s *Server) findDevice(w http.ResponseWriter, r *http.Request, class string) (*domain.Overkiz, *domain.Device, bool) {
+	identifier, err := url.PathUnescape(chi.URLParam(r, "device"))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "Invalid device")
+		return nil, nil, false
+	}
+	for _, overkiz := range s.scopedGateways(r) {
+		device := overkiz.ClassDevice(class, identifier)
+		if device != nil {
+			return overkiz, device, true
+		}
 	}
+	writeError(w, r, http.StatusNotFound, fmt.Sprintf("Device %s not found", identifier))
+	return nil, nil, false
+}
+
+type openCloseRequest struct {
+	Percentage *int `json:"percentage"`
+}
+
+// openCloseAction converts an open or close action with an optional percentage to the command name and parameters that
+// should be sent to the gateway. The percentage is either a url parameter or part of the json body of the request.
+func openCloseAction(actionName string, r *http.Request) (string, []any, error) {
+	var value int
+	if percentage := chi.URLParam(r, "percentage"); percentage != "" {
+		var err error
+		value, err = strconv.Atoi(percentage)
+		if err != nil {
+			return "", nil, err
+		}
+	} else {
+		request := &openCloseRequest{}
+		err := render.DecodeJSON(r.Body, request)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return "", nil, err
+		}
+		if request.Percentage == nil {
+			return actionName, make([]any, 0), nil
+		}
+		value = *request.Percentage
+	}
+	if value < 0 {
+		value = 0
+	} else if value > 100 {
+		value = 100
+	}
+	if "open" == actionName {
+		return "setClosure", []any{100 - value}, nil
+	}
+	return "setClosure", []any{value}, nil
+}
+
+func decodeCommandRequest(w http.ResponseWriter, r *http.Request) (*commandRequest, bool) {
+	request := &commandRequest{}
+	err := render.DecodeJSON(r.Body, request)
+	if err != nil || request.Name == "" {
+		writeError(w, r, http.StatusBadRequest, "Invalid command request")
+		return nil, false
+	}
+	return request, true
+}
+
+// writeExecutionResponse writes the id of the started execution, or an error response when the execution could not be
+// started. A nil execution without an error means there were no devices to execute the command on.
+func writeExecutionResponse(w http.ResponseWriter, r *http.Request, execution *domain.Execution, err error, notFoundMessage string) {
+	var executions []*domain.Execution
+	if execution != nil {
+		executions = append(executions, execution)
+	}
+	writeExecutionsResponse(w, r, executions, err, notFoundMessage)
+}
+
+type executionResponse struct {
+	Status  string   `json:"status"`
+	ExecId  string   `json:"exec_id,omitempty"`
+	ExecIds []string `json:"exec_ids,omitempty"`
+}
+
+// writeExecutionsResponse writes the ids of the executions that were started on one or more gateways, or an error
+// response when an execution could not be started. A single execution is reported in exec_id, multiple executions in
+// exec_ids.
+func writeExecutionsResponse(w http.ResponseWriter, r *http.Request, executions []*domain.Execution, err error, notFoundMessage string) {
+	if err != nil {
+		log.Errorf("Failed to execute command: %v", err)
+		writeError(w, r, http.StatusBadGateway, err.Error())
+		return
+	}
+	if len(executions) == 0 {
+		writeError(w, r, http.StatusNotFound, notFoundMessage)
+		return
+	}
+	response := &executionResponse{
+		Status: "Executing",
+	}
+	if len(executions) == 1 {
+		response.ExecId = executions[0].Id
+	} else {
+		for _, execution := range executions {
+			response.ExecIds = append(response.ExecIds, execution.Id)
+		}
+	}
+	render.Status(r, http.StatusAccepted)
+	render.JSON(w, r, response)
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, statusCode int, message string) {
+	render.Status(r, statusCode)
+	render.JSON(w, r, map[string]string{"error": message})
 }
 
 func (s *Server) rollerShutter(actionName string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		parameters := make([]string, 0)
-		action := actionName
-		if "open" == actionName || "close" == actionName {
-			percentage := chi.URLParam(r, "percentage")
-			if percentage != "" {
-				value, err := strconv.Atoi(percentage)
-				if err != nil {
-					w.WriteHeader(http.StatusBadRequest)
-					return
-				}
-				if value < 0 {
-					value = 0
-				} else if value > 100 {
-					value = 100
-				}
-				if "open" == actionName {
-					parameters = []string{fmt.Sprintf("%d", 100-value)}
-				} else {
-					parameters = []string{fmt.Sprintf("%d", value)}
-				}
-				action = "setClosure"
+		action, parameters, err := openCloseAction(actionName, r)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "Invalid percentage")
+			return
+		}
+		s.executeOnGateways(w, r, "No RollerShutters found", func(overkiz *domain.Overkiz) (*domain.Execution, error) {
+			return overkiz.RollerShutters(action, parameters)
+		})
+	}
+}
+
+func (s *Server) getExecution() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		execId := chi.URLParam(r, "execId")
+		_, execution, err := s.findExecution(r, execId)
+		if execution == nil && err != nil {
+			log.Errorf("Failed to retrieve execution %s: %v", execId, err)
+			writeError(w, r, http.StatusBadGateway, err.Error())
+			return
+		}
+		if execution == nil {
+			writeError(w, r, http.StatusNotFound, fmt.Sprintf("Execution %s not found", execId))
+			return
+		}
+		render.JSON(w, r, execution)
+	}
+}
+
+func (s *Server) cancelExecution() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		execId := chi.URLParam(r, "execId")
+		overkiz, execution, err := s.findExecution(r, execId)
+		if execution == nil && err != nil {
+			log.Errorf("Failed to retrieve execution %s: %v", execId, err)
+			writeError(w, r, http.StatusBadGateway, err.Error())
+			return
+		}
+		if execution == nil {
+			writeError(w, r, http.StatusNotFound, fmt.Sprintf("Execution %s not found", execId))
+			return
+		}
+		err = overkiz.CancelExecution(execId)
+		if err != nil {
+			log.Errorf("Failed to cancel execution %s: %v", execId, err)
+			writeError(w, r, http.StatusBadGateway, err.Error())
+			return
+		}
+		render.Status(r, http.StatusAccepted)
+		render.JSON(w, r, map[string]string{"status": "Cancelling", "exec_id": execId})
+	}
+}
+
+func (s *Server) cancelExecutions() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var errs []error
+		for _, overkiz := range s.scopedGateways(r) {
+			err := overkiz.CancelExecutions()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", overkiz.Name(), err))
 			}
 		}
-		deviceCount, _ := s.overkiz.RollerShutters(action, parameters)
-		if deviceCount == 0 {
-			w.WriteHeader(404)
-			_, err := w.Write([]byte("{\"error\":\"No RollerShutters found\"}"))
+		if err := errors.Join(errs...); err != nil {
+			log.Errorf("Failed to cancel executions: %v", err)
+			writeError(w, r, http.StatusBadGateway, err.Error())
+			return
+		}
+		render.Status(r, http.StatusAccepted)
+		render.JSON(w, r, map[string]string{"status": "Cancelling"})
+	}
+}
+
+func (s *Server) stop() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		class := s.deviceClass(r, chi.URLParam(r, "class"))
+		s.executeOnGateways(w, r, fmt.Sprintf("No %s devices found", class), func(overkiz *domain.Overkiz) (*domain.Execution, error) {
+			return overkiz.ExecuteCommand(class, "stop", nil)
+		})
+	}
+}
+
+// deviceClass returns the uiClass of the devices addressed by the class in the url. Besides the uiClass, like
+// RollerShutter, its plural is accepted, like in the RollerShutters routes.
+func (s *Server) deviceClass(r *http.Request, class string) string {
+	singular, plural := strings.CutSuffix(class, "s")
+	if !plural {
+		return class
+	}
+	for _, overkiz := range s.scopedGateways(r) {
+		if len(overkiz.Devices(class)) > 0 {
+			return class
+		}
+	}
+	return singular
+}
+
+func (s *Server) getScenarios() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		actionGroups := make([]*domain.ActionGroup, 0)
+		for _, overkiz := range s.scopedGateways(r) {
+			gatewayActionGroups, err := overkiz.ActionGroups()
 			if err != nil {
-				log.Errorf("Error writing response: %v", err)
+				log.Errorf("Failed to retrieve scenarios of gateway %s: %v", overkiz.Name(), err)
+				writeError(w, r, http.StatusBadGateway, err.Error())
+				return
 			}
-		} else {
-			w.WriteHeader(202)
-			_, err := w.Write([]byte("{\"status\":\"Executing\"}"))
+			actionGroups = append(actionGroups, gatewayActionGroups...)
+		}
+		render.JSON(w, r, actionGroups)
+	}
+}
+
+func (s *Server) executeScenario() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		scenario, err := url.PathUnescape(chi.URLParam(r, "scenario"))
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "Invalid scenario")
+			return
+		}
+		// A gateway that cannot be queried does not prevent the scenario from being found on another gateway.
+		var execution *domain.Execution
+		var errs []error
+		for _, overkiz := range s.scopedGateways(r) {
+			execution, err = overkiz.ExecuteActionGroup(scenario)
 			if err != nil {
-				log.Errorf("Error writing response: %v", err)
+				errs = append(errs, fmt.Errorf("%s: %w", overkiz.Name(), err))
+			} else if execution != nil {
+				break
+			}
+		}
+		if execution != nil {
+			errs = nil
+		}
+		writeExecutionResponse(w, r, execution, errors.Join(errs...), fmt.Sprintf("Scenario %s not found", scenario))
+	}
+}
+
+func (s *Server) getScenes() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		scenes := make([]*domain.Scene, 0)
+		for _, overkiz := range s.scopedGateways(r) {
+			scenes = append(scenes, overkiz.Scenes()...)
+		}
+		render.JSON(w, r, scenes)
+	}
+}
+
+func (s *Server) executeScene() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		scene, err := url.PathUnescape(chi.URLParam(r, "scene"))
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "Invalid scene")
+			return
+		}
+		var execution *domain.Execution
+		for _, overkiz := range s.scopedGateways(r) {
+			execution, err = overkiz.ExecuteScene(scene)
+			if execution != nil || err != nil {
+				break
 			}
 		}
+		writeExecutionResponse(w, r, execution, err, fmt.Sprintf("Scene %s not found", scene))
 	}
 }
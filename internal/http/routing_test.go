@@ -0,0 +1,296 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"overkiz-adapter/internal/config"
+	"overkiz-adapter/internal/domain"
+	"overkiz-adapter/internal/domain/domaintest"
+	"strings"
+	"testing"
+)
+
+const testDevices = `[{"label":"Bedroom","deviceURL":"io://1234-5678-9012/1","definition":{"uiClass":"RollerShutter"}},` +
+	`{"label":"Living room","deviceURL":"io://1234-5678-9012/2","definition":{"uiClass":"Light"}}]`
+
+func TestDevices(t *testing.T) {
+	server := newTestServer(t, &config.Http{}, newTestGateway(t).Settings())
+	response := serve(server, "GET", "/api/v1/devices", "")
+	var devices []*domain.Device
+	err := json.Unmarshal(response.Body.Bytes(), &devices)
+	if err != nil || len(devices) != 2 {
+		t.Fatalf("Expected a list of 2 devices, got %s", response.Body)
+	}
+	if response.Header().Get(lastRefreshHeader) == "" || response.Header().Get(lastErrorHeader) != "" {
+		t.Errorf("Unexpected refresh headers %v", response.Header())
+	}
+	for _, class := range []string{"RollerShutter", "RollerShutters"} {
+		response = serve(server, "GET", "/api/v1/devices/"+class, "")
+		if err = json.Unmarshal(response.Body.Bytes(), &devices); err != nil || len(devices) != 1 || devices[0].Label != "Bedroom" {
+			t.Errorf("Expected the RollerShutter for %s, got %s", class, response.Body)
+		}
+	}
+}
+
+func TestDeviceStates(t *testing.T) {
+	gateway := newTestGateway(t)
+	gateway.SetDeviceStates("io://1234-5678-9012/1", `[{"name":"core:ClosureState","type":1,"value":"100"},`+
+		`{"name":"core:TargetClosureState","type":1,"value":40},{"name":"core:Temperature","type":2,"value":"21.5"},`+
+		`{"name":"core:MovingState","type":6,"value":"true"},{"name":"core:OpenClosedState","type":3,"value":"closed"}]`)
+	server := newTestServer(t, &config.Http{}, gateway.Settings())
+
+	response := serve(server, "GET", "/api/v1/devices/RollerShutter/bedroom/states", "")
+	var states []*domain.State
+	if err := json.Unmarshal(response.Body.Bytes(), &states); response.Code != http.StatusOK || err != nil || len(states) != 5 {
+		t.Fatalf("Expected the states of the bedroom, got %d %s", response.Code, response.Body)
+	}
+	// The string values of the gateway are converted to the type of the state.
+	expected := []any{float64(100), float64(40), 21.5, true, "closed"}
+	for ix, state := range states {
+		if state.Value != expected[ix] {
+			t.Errorf("Expected %s to be %v, got %v (%T)", state.Name, expected[ix], state.Value, state.Value)
+		}
+	}
+
+	response = serve(server, "GET", "/api/v1/devices/RollerShutters/Bedroom/states", "")
+	if response.Code != http.StatusOK {
+		t.Errorf("Expected the states of the bedroom by the plural class, got %d %s", response.Code, response.Body)
+	}
+	response = serve(server, "GET", "/api/v1/devices/Light/Bedroom/states", "")
+	if response.Code != http.StatusNotFound {
+		t.Errorf("Expected no Light named Bedroom, got %d %s", response.Code, response.Body)
+	}
+}
+
+func TestExecuteCommand(t *testing.T) {
+	gateway := newTestGateway(t)
+	server := newTestServer(t, &config.Http{}, gateway.Settings())
+	response := serve(server, "POST", "/api/v1/devices/Light/commands", `{"name":"setIntensity","parameters":[50]}`)
+	if response.Code != http.StatusAccepted {
+		t.Fatalf("Expected the command to be executed, got %d %s", response.Code, response.Body)
+	}
+	expected := `{"label":"setIntensityLights","actions":[{"commands":[{"name":"setIntensity","parameters":[50]}],` +
+		`"deviceURL":"io://1234-5678-9012/2"}]}`
+	if command := <-gateway.Commands; command != expected {
+		t.Errorf("Expected command %s, got %s", expected, command)
+	}
+
+	// The plural of the class addresses the same devices.
+	response = serve(server, "POST", "/api/v1/devices/RollerShutters/commands", `{"name":"setClosure","parameters":[30]}`)
+	if response.Code != http.StatusAccepted {
+		t.Fatalf("Expected the command to be executed on the roller shutters, got %d %s", response.Code, response.Body)
+	}
+	if command := <-gateway.Commands; !strings.Contains(command, `"deviceURL":"io://1234-5678-9012/1"`) {
+		t.Errorf("Expected a command for the bedroom, got %s", command)
+	}
+
+	response = serve(server, "POST", "/api/v1/devices/Light/commands", `{"parameters":[50]}`)
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("Expected a missing command name to be refused, got %d %s", response.Code, response.Body)
+	}
+}
+
+func TestDevice(t *testing.T) {
+	gateway := newTestGateway(t)
+	server := newTestServer(t, &config.Http{}, gateway.Settings())
+	paths := []string{
+		"/api/v1/device/" + url.PathEscape("io://1234-5678-9012/1") + "/close/30",
+		"/api/v1/device/bEdRoOm/close/30",
+	}
+	for _, path := range paths {
+		response := serve(server, "POST", path, "")
+		if response.Code != http.StatusAccepted {
+			t.Fatalf("Expected the device to be closed for %s, got %d %s", path, response.Code, response.Body)
+		}
+		command := <-gateway.Commands
+		if !strings.Contains(command, `"deviceURL":"io://1234-5678-9012/1"`) || strings.Contains(command, "/2") {
+			t.Errorf("Expected a command for the bedroom only, got %s", command)
+		}
+	}
+	response := serve(server, "POST", "/api/v1/device/Kitchen/close", "")
+	if response.Code != http.StatusNotFound {
+		t.Errorf("Expected an unknown device, got %d %s", response.Code, response.Body)
+	}
+}
+
+func TestGetActions(t *testing.T) {
+	gateway := newTestGateway(t)
+	server := newTestServer(t, &config.Http{}, gateway.Settings())
+	for _, method := range []string{"POST", "PUT"} {
+		if response := serve(server, method, "/api/v1/devices/RollerShutters/close", ""); response.Code != http.StatusAccepted {
+			t.Errorf("Expected %s to execute the action, got %d %s", method, response.Code, response.Body)
+		}
+	}
+	response := serve(server, "GET", "/api/v1/devices/RollerShutters/close", "")
+	if response.Code != http.StatusMethodNotAllowed || len(gateway.Commands) != 2 {
+		t.Errorf("Expected GET not to be allowed, got %d %s", response.Code, response.Body)
+	}
+
+	server = newTestServer(t, &config.Http{AllowGetActions: true}, gateway.Settings())
+	response = serve(server, "GET", "/api/v1/devices/RollerShutters/close", "")
+	if response.Code != http.StatusAccepted || len(gateway.Commands) != 3 {
+		t.Errorf("Expected GET to execute the action when allowed, got %d %s", response.Code, response.Body)
+	}
+}
+
+func TestScenarios(t *testing.T) {
+	gateway := newTestGateway(t)
+	gateway.SetActionGroups(`[{"oid":"4f3e2d1c","label":"Good night","actions":[{"deviceURL":"io://1234-5678-9012/1"},` +
+		`{"deviceURL":"io://1234-5678-9012/2"}]},{"oid":"9a8b7c6d","label":"Morning","actions":[]}]`)
+	server := newTestServer(t, &config.Http{}, gateway.Settings())
+
+	response := serve(server, "GET", "/api/v1/scenarios", "")
+	var actionGroups []*domain.ActionGroup
+	err := json.Unmarshal(response.Body.Bytes(), &actionGroups)
+	if err != nil || len(actionGroups) != 2 || actionGroups[0].Label != "Good night" || actionGroups[0].Gateway != "test" ||
+		len(actionGroups[0].DeviceURLs) != 2 {
+		t.Fatalf("Expected the scenarios of the gateway, got %s", response.Body)
+	}
+
+	for _, scenario := range []string{"9a8b7c6d", "mORNING", "good%20NIGHT"} {
+		response = serve(server, "POST", "/api/v1/scenarios/"+scenario+"/execute", "")
+		if response.Code != http.StatusAccepted {
+			t.Errorf("Expected scenario %s to be executed, got %d %s", scenario, response.Code, response.Body)
+			continue
+		}
+		expected := "9a8b7c6d"
+		if strings.HasPrefix(scenario, "good") {
+			expected = "4f3e2d1c"
+		}
+		if oid := <-gateway.ActionGroupExecutions; oid != expected {
+			t.Errorf("Expected action group %s to be executed for %s, got %s", expected, scenario, oid)
+		}
+	}
+
+	response = serve(server, "POST", "/api/v1/scenarios/Evening/execute", "")
+	if response.Code != http.StatusNotFound || len(gateway.ActionGroupExecutions) != 0 {
+		t.Errorf("Expected an unknown scenario, got %d %s", response.Code, response.Body)
+	}
+}
+
+func TestCancelExecution(t *testing.T) {
+	gateway := newTestGateway(t)
+	server := newTestServer(t, &config.Http{}, gateway.Settings())
+	response := serve(server, "POST", "/api/v1/device/Bedroom/close", "")
+	var execution *executionResponse
+	if err := json.Unmarshal(response.Body.Bytes(), &execution); err != nil || execution.ExecId == "" {
+		t.Fatalf("Expected an execution, got %d %s", response.Code, response.Body)
+	}
+
+	response = serve(server, "DELETE", "/api/v1/executions/"+execution.ExecId, "")
+	if response.Code != http.StatusAccepted {
+		t.Fatalf("Expected the execution to be cancelled, got %d %s", response.Code, response.Body)
+	}
+	if execId := <-gateway.CancelledExecutions; execId != execution.ExecId {
+		t.Errorf("Expected execution %s to be cancelled at the gateway, got %s", execution.ExecId, execId)
+	}
+
+	response = serve(server, "DELETE", "/api/v1/executions/unknown", "")
+	if response.Code != http.StatusNotFound || len(gateway.CancelledExecutions) != 0 {
+		t.Errorf("Expected an unknown execution, got %d %s", response.Code, response.Body)
+	}
+}
+
+func TestExecuteScene(t *testing.T) {
+	gateway := newTestGateway(t)
+	server := newTestServer(t, &config.Http{}, gateway.Settings())
+	err := server.gateways[0].SetScenes([]*domain.Scene{{Name: "Good night", Gateway: "test", Actions: []*domain.SceneAction{
+		{Device: "Bedroom", Command: "close"},
+		{Device: "Living room", Command: "off"},
+		{Device: "io://1234-5678-9012/1", Command: "setClosure", Parameters: []any{100}},
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := serve(server, "POST", "/api/v1/scenes/good%20night", "")
+	if response.Code != http.StatusAccepted {
+		t.Fatalf("Expected the scene to be executed, got %d %s", response.Code, response.Body)
+	}
+	// The scene is a single execution with the commands grouped per device.
+	expected := `{"label":"Good night","actions":[{"commands":[{"name":"close"},{"name":"setClosure","parameters":[100]}],` +
+		`"deviceURL":"io://1234-5678-9012/1"},{"commands":[{"name":"off"}],"deviceURL":"io://1234-5678-9012/2"}]}`
+	if command := <-gateway.Commands; command != expected || len(gateway.Commands) != 0 {
+		t.Errorf("Expected command %s, got %s and %d more", expected, command, len(gateway.Commands))
+	}
+}
+
+func TestMultipleGateways(t *testing.T) {
+	house, garage := newTestGateway(t), newTestGateway(t)
+	houseSettings, garageSettings := house.Settings(), garage.Settings()
+	houseSettings.Name, garageSettings.Name = "house", "garage"
+	server := newTestServer(t, &config.Http{}, houseSettings, garageSettings)
+
+	response := serve(server, "POST", "/api/v1/devices/RollerShutters/close", "")
+	var execution *executionResponse
+	err := json.Unmarshal(response.Body.Bytes(), &execution)
+	if response.Code != http.StatusAccepted || err != nil || len(execution.ExecIds) != 2 {
+		t.Fatalf("Expected an execution on both gateways, got %d %s", response.Code, response.Body)
+	}
+	if len(house.Commands) != 1 || len(garage.Commands) != 1 {
+		t.Errorf("Expected a command on both gateways, got %d and %d", len(house.Commands), len(garage.Commands))
+	}
+
+	response = serve(server, "POST", "/api/v1/gateways/garage/devices/RollerShutter/stop", "")
+	execution = nil
+	err = json.Unmarshal(response.Body.Bytes(), &execution)
+	if response.Code != http.StatusAccepted || err != nil || execution.ExecId == "" || len(execution.ExecIds) != 0 {
+		t.Fatalf("Expected a single execution, got %d %s", response.Code, response.Body)
+	}
+	if len(house.Commands) != 1 || len(garage.Commands) != 2 {
+		t.Errorf("Expected a command on the garage only, got %d and %d", len(house.Commands), len(garage.Commands))
+	}
+
+	response = serve(server, "POST", "/api/v1/devices/Awning/commands", `{"name":"close"}`)
+	if response.Code != http.StatusNotFound {
+		t.Errorf("Expected no Awning devices, got %d %s", response.Code, response.Body)
+	}
+
+	response = serve(server, "DELETE", "/api/v1/executions", "")
+	if response.Code != http.StatusAccepted || house.Cancellations.Load() != 1 || garage.Cancellations.Load() != 1 {
+		t.Errorf("Expected the executions of both gateways to be cancelled, got %d %s", response.Code, response.Body)
+	}
+}
+
+// newTestGateway starts a fake gateway with the test devices, the given json events are returned by the consecutive
+// event fetches.
+func newTestGateway(t *testing.T, events ...string) *domaintest.Gateway {
+	return domaintest.NewGateway(t, testDevices, events...)
+}
+
+// newTestServer creates a server that is connected to the given gateways.
+func newTestServer(t *testing.T, httpConfig *config.Http, gateways ...*domain.Gateway) *Server {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	overkizs := make([]*domain.Overkiz, 0, len(gateways))
+	for _, gateway := range gateways {
+		overkiz, err := domain.NewOverkiz(gateway, ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		overkizs = append(overkizs, overkiz)
+	}
+	server, err := NewServer(httpConfig, nil, nil, overkizs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server
+}
+
+// serve sends a request with the given body to the server, the given headers are given as name and value pairs.
+func serve(server *Server, method string, path string, body string, headers ...string) *httptest.ResponseRecorder {
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	request := httptest.NewRequest(method, path, reader)
+	for i := 0; i+1 < len(headers); i += 2 {
+		request.Header.Set(headers[i], headers[i+1])
+	}
+	response := httptest.NewRecorder()
+	server.server.Handler.ServeHTTP(response, request)
+	return response
+}
@@ -0,0 +1,47 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"net/http"
+	"overkiz-adapter/internal/rules"
+)
+
+type webhookResponse struct {
+	Status string   `json:"status"`
+	Rules  []string `json:"rules"`
+}
+
+func (s *Server) getRules() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		render.JSON(w, r, s.rules.Rules())
+	}
+}
+
+func (s *Server) enableRule(enabled bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := chi.URLParam(r, "rule")
+		status, err := s.rules.SetEnabled(name, enabled)
+		if errors.Is(err, rules.ErrUnknownRule) {
+			writeError(w, r, http.StatusNotFound, fmt.Sprintf("Rule %s not found", name))
+			return
+		}
+		render.JSON(w, r, status)
+	}
+}
+
+// triggerWebhook triggers the rules that are triggered by the incoming webhook in the url.
+func (s *Server) triggerWebhook() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := chi.URLParam(r, "webhook")
+		triggered, err := s.rules.TriggerWebhook(name)
+		if errors.Is(err, rules.ErrUnknownWebhook) {
+			writeError(w, r, http.StatusNotFound, fmt.Sprintf("Webhook %s not found", name))
+			return
+		}
+		render.Status(r, http.StatusAccepted)
+		render.JSON(w, r, &webhookResponse{Status: "Triggered", Rules: triggered})
+	}
+}
@@ -0,0 +1,80 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"overkiz-adapter/internal/config"
+	"overkiz-adapter/internal/domain"
+	"overkiz-adapter/internal/rules"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRules(t *testing.T) {
+	gateway := newTestGateway(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	overkiz, err := domain.NewOverkiz(gateway.Settings(), ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ruleConfigs := []*config.Rule{{
+		Name:    "Doorbell",
+		Trigger: &config.RuleTrigger{Webhook: "doorbell"},
+		Actions: []*config.Action{{Device: "Living room", Command: "on"}},
+	}}
+	engine, err := rules.NewEngine(ruleConfigs, &config.Location{Timezone: "UTC"}, overkiz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := NewServer(&config.Http{}, engine, nil, overkiz)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var statuses []*rules.Status
+	response := serve(server, "GET", "/api/v1/rules", "")
+	if err = json.Unmarshal(response.Body.Bytes(), &statuses); err != nil || len(statuses) != 1 || !statuses[0].Enabled ||
+		statuses[0].Trigger.Webhook != "doorbell" {
+		t.Fatalf("Expected the enabled rule, got %s", response.Body)
+	}
+
+	var triggered *webhookResponse
+	response = serve(server, "POST", "/api/v1/hooks/doorbell", "")
+	if err = json.Unmarshal(response.Body.Bytes(), &triggered); response.Code != http.StatusAccepted || err != nil ||
+		len(triggered.Rules) != 1 || triggered.Rules[0] != "Doorbell" {
+		t.Fatalf("Expected the rule to be triggered, got %d %s", response.Code, response.Body)
+	}
+	select {
+	case command := <-gateway.Commands:
+		if !strings.Contains(command, `"deviceURL":"io://1234-5678-9012/2"`) {
+			t.Errorf("Expected the living room to be switched on, got %s", command)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the actions of the rule to be executed")
+	}
+
+	// A disabled rule is no longer triggered by its webhook.
+	var status *rules.Status
+	response = serve(server, "POST", "/api/v1/rules/doorbell/disable", "")
+	if err = json.Unmarshal(response.Body.Bytes(), &status); err != nil || status.Enabled {
+		t.Errorf("Expected a disabled rule, got %s", response.Body)
+	}
+	response = serve(server, "POST", "/api/v1/hooks/doorbell", "")
+	if err = json.Unmarshal(response.Body.Bytes(), &triggered); response.Code != http.StatusAccepted || err != nil ||
+		len(triggered.Rules) != 0 {
+		t.Errorf("Expected no rules to be triggered, got %d %s", response.Code, response.Body)
+	}
+	response = serve(server, "POST", "/api/v1/rules/doorbell/enable", "")
+	if err = json.Unmarshal(response.Body.Bytes(), &status); err != nil || !status.Enabled {
+		t.Errorf("Expected an enabled rule, got %s", response.Body)
+	}
+
+	for _, path := range []string{"/api/v1/rules/unknown/enable", "/api/v1/rules/unknown/disable", "/api/v1/hooks/unknown"} {
+		if response = serve(server, "POST", path, ""); response.Code != http.StatusNotFound {
+			t.Errorf("Expected %s not to be found, got %d %s", path, response.Code, response.Body)
+		}
+	}
+}
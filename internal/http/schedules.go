@@ -0,0 +1,38 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"net/http"
+	"overkiz-adapter/internal/schedule"
+)
+
+func (s *Server) getSchedules() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		render.JSON(w, r, s.scheduler.Schedules())
+	}
+}
+
+func (s *Server) pauseSchedule(paused bool) http.HandlerFunc {
+	return s.updateSchedule(func(name string) (*schedule.Status, error) {
+		return s.scheduler.Pause(name, paused)
+	})
+}
+
+func (s *Server) skipSchedule() http.HandlerFunc {
+	return s.updateSchedule(s.scheduler.SkipNext)
+}
+
+func (s *Server) updateSchedule(update func(name string) (*schedule.Status, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := chi.URLParam(r, "schedule")
+		status, err := update(name)
+		if errors.Is(err, schedule.ErrUnknownSchedule) {
+			writeError(w, r, http.StatusNotFound, fmt.Sprintf("Schedule %s not found", name))
+			return
+		}
+		render.JSON(w, r, status)
+	}
+}
@@ -0,0 +1,77 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"overkiz-adapter/internal/config"
+	"overkiz-adapter/internal/domain"
+	"overkiz-adapter/internal/schedule"
+	"testing"
+	"time"
+)
+
+func TestSchedules(t *testing.T) {
+	schedules := []*config.Schedule{{
+		Name:    "Morning",
+		At:      "0 9 * * *",
+		Actions: []*config.Action{{Class: "RollerShutter", Command: "open"}},
+	}}
+	ctx, cancel := context.WithCancel(context.Background())
+	overkiz, err := domain.NewOverkiz(newTestGateway(t).Settings(), ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scheduler, err := schedule.NewScheduler(schedules, &config.Location{Timezone: "UTC"}, overkiz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		_ = scheduler.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	server, err := NewServer(&config.Http{}, nil, scheduler, overkiz)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The next run is set as soon as the scheduler is running.
+	var statuses []*schedule.Status
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		response := serve(server, "GET", "/api/v1/schedules", "")
+		if err = json.Unmarshal(response.Body.Bytes(), &statuses); err != nil || len(statuses) != 1 {
+			t.Fatalf("Expected a single schedule, got %s", response.Body)
+		}
+		if statuses[0].NextRun != nil || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if statuses[0].NextRun == nil || statuses[0].NextRun.Hour() != 9 || statuses[0].Paused || statuses[0].SkipNext {
+		t.Errorf("Expected an active schedule with a next run at 9:00, got %+v", statuses[0])
+	}
+
+	var status *schedule.Status
+	response := serve(server, "POST", "/api/v1/schedules/morning/skip", "")
+	if err = json.Unmarshal(response.Body.Bytes(), &status); err != nil || !status.SkipNext || status.Paused {
+		t.Errorf("Expected the next run to be skipped, got %s", response.Body)
+	}
+	response = serve(server, "POST", "/api/v1/schedules/morning/pause", "")
+	if err = json.Unmarshal(response.Body.Bytes(), &status); err != nil || !status.Paused || !status.SkipNext {
+		t.Errorf("Expected a paused schedule, got %s", response.Body)
+	}
+	// Resuming a schedule also cancels skipping its next run.
+	response = serve(server, "POST", "/api/v1/schedules/morning/resume", "")
+	if err = json.Unmarshal(response.Body.Bytes(), &status); err != nil || status.Paused || status.SkipNext {
+		t.Errorf("Expected an active schedule, got %s", response.Body)
+	}
+	response = serve(server, "POST", "/api/v1/schedules/evening/pause", "")
+	if response.Code != http.StatusNotFound {
+		t.Errorf("Expected an unknown schedule, got %d %s", response.Code, response.Body)
+	}
+}
@@ -0,0 +1,173 @@
+package http
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"overkiz-adapter/internal/config"
+	"overkiz-adapter/internal/log"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// certificateStore holds the certificate and the client CAs of the https server, so they can be replaced while the
+// server is running.
+type certificateStore struct {
+	certFile     string
+	keyFile      string
+	clientCaFile string
+	certificate  atomic.Pointer[tls.Certificate]
+	clientCAs    atomic.Pointer[x509.CertPool]
+}
+
+// newTlsConfig creates the tls configuration of the https server. A self-signed certificate is generated first when it
+// is enabled and the certificate does not exist yet. With api keys a client certificate is optional, also when it must
+// be signed by a client CA.
+func newTlsConfig(tlsConfig *config.Tls, apiKeys bool) (*tls.Config, *certificateStore, error) {
+	if tlsConfig.SelfSigned {
+		_, err := os.Stat(tlsConfig.CertFile)
+		if errors.Is(err, os.ErrNotExist) {
+			err = generateCertificate(tlsConfig.CertFile, tlsConfig.KeyFile)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to generate self-signed certificate: %w", err)
+			}
+			log.Infof("Generated self-signed certificate %s", tlsConfig.CertFile)
+		} else if err != nil {
+			return nil, nil, err
+		}
+	}
+	store := &certificateStore{
+		certFile:     tlsConfig.CertFile,
+		keyFile:      tlsConfig.KeyFile,
+		clientCaFile: tlsConfig.ClientCaFile,
+	}
+	err := store.load()
+	if err != nil {
+		return nil, nil, err
+	}
+	result := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: store.getCertificate,
+	}
+	if tlsConfig.MinVersion == "1.3" {
+		result.MinVersion = tls.VersionTLS13
+	}
+	if tlsConfig.ClientCaFile != "" {
+		result.ClientAuth = tls.RequireAndVerifyClientCert
+		if apiKeys {
+			// Clients without a certificate can still authenticate with an api key.
+			result.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+		// Every connection uses the current client CAs, so they can be reloaded. The protocols are set here, because the
+		// server only adds them to its own copy of the configuration.
+		result.NextProtos = []string{"h2", "http/1.1"}
+		base := result.Clone()
+		result.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			connectionConfig := base.Clone()
+			connectionConfig.ClientCAs = store.clientCAs.Load()
+			return connectionConfig, nil
+		}
+	} else if len(tlsConfig.ClientCertificates) > 0 {
+		// Without a CA the certificates are only identified by their fingerprint, and clients without a certificate
+		// can still authenticate with an api key.
+		result.ClientAuth = tls.RequestClientCert
+	}
+	return result, store, nil
+}
+
+// load reads the certificate, key and client CAs from their files. The current certificate and client CAs are kept when
+// they cannot be read.
+func (c *certificateStore) load() error {
+	var clientCAs *x509.CertPool
+	if c.clientCaFile != "" {
+		data, err := os.ReadFile(c.clientCaFile)
+		if err != nil {
+			return err
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(data) {
+			return fmt.Errorf("no certificates found in %s", c.clientCaFile)
+		}
+	}
+	certificate, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate %s: %w", c.certFile, err)
+	}
+	certificate.Leaf, err = x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("failed to parse certificate %s: %w", c.certFile, err)
+	}
+	c.certificate.Store(&certificate)
+	c.clientCAs.Store(clientCAs)
+	return nil
+}
+
+func (c *certificateStore) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return c.certificate.Load(), nil
+}
+
+// generateCertificate writes a self-signed certificate for the host name and addresses of this host, valid for ten
+// years, together with its private key.
+func generateCertificate(certFile string, keyFile string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               pkix.Name{CommonName: "overkiz-adapter"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		template.Subject.CommonName = hostname
+		template.DNSNames = append(template.DNSNames, hostname, hostname+".local")
+	}
+	if addresses, err := net.InterfaceAddrs(); err == nil {
+		for _, address := range addresses {
+			if network, ok := address.(*net.IPNet); ok && !network.IP.IsLoopback() && !network.IP.IsLinkLocalUnicast() {
+				template.IPAddresses = append(template.IPAddresses, network.IP)
+			}
+		}
+	}
+	certificate, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	privateKey, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	err = writePem(keyFile, "PRIVATE KEY", privateKey, 0600)
+	if err != nil {
+		return err
+	}
+	return writePem(certFile, "CERTIFICATE", certificate, 0644)
+}
+
+func writePem(file string, blockType string, data []byte, perm os.FileMode) error {
+	err := os.MkdirAll(filepath.Dir(file), 0755)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: data}), perm)
+}
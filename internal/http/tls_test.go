@@ -0,0 +1,231 @@
+package http
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"overkiz-adapter/internal/config"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSelfSignedCertificate(t *testing.T) {
+	dir := t.TempDir()
+	tlsConfig := &config.Tls{
+		CertFile:   filepath.Join(dir, "certs", "adapter.crt"),
+		KeyFile:    filepath.Join(dir, "certs", "adapter.key"),
+		SelfSigned: true,
+		MinVersion: "1.3",
+	}
+	result, store, err := newTlsConfig(tlsConfig, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.MinVersion != tls.VersionTLS13 {
+		t.Errorf("Expected minimum version 1.3, got %x", result.MinVersion)
+	}
+	info, err := os.Stat(tlsConfig.KeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected the key to be readable by the owner only, got %v", info.Mode().Perm())
+	}
+	certificate, _ := result.GetCertificate(nil)
+	if certificate == nil || certificate.Leaf == nil || certificate.Leaf.VerifyHostname("localhost") != nil {
+		t.Fatal("Expected a certificate for localhost")
+	}
+
+	// A second start uses the persisted certificate instead of generating a new one.
+	_, second, err := newTlsConfig(tlsConfig, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !second.certificate.Load().Leaf.Equal(certificate.Leaf) {
+		t.Error("Expected the persisted certificate to be reused")
+	}
+
+	// A failed reload keeps the current certificate.
+	err = os.WriteFile(tlsConfig.CertFile, []byte("garbage"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.load() == nil {
+		t.Error("Expected an error for an invalid certificate")
+	}
+	if current, _ := store.getCertificate(nil); current != certificate {
+		t.Error("Expected the current certificate to be kept")
+	}
+}
+
+func TestCertificateIdentity(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "client.crt")
+	err := generateCertificate(certFile, filepath.Join(dir, "client.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(certFile)
+	block, _ := pem.Decode(data)
+	certificate, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fingerprint := sha256.Sum256(certificate.Raw)
+	certificates := []*config.ClientCertificate{
+		{Name: "subject", Subject: certificate.Subject.CommonName, Scopes: []string{scopeRead}},
+		{Name: "fingerprint", Fingerprint: hex.EncodeToString(fingerprint[:]), Scopes: []string{scopeExecute}},
+	}
+	request := httptest.NewRequest("GET", "/", nil)
+	if certificateIdentity(request, certificates) != nil {
+		t.Error("Expected no identity without tls")
+	}
+	request.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{certificate}}
+	if client := certificateIdentity(request, certificates); client == nil || client.name != "fingerprint" {
+		t.Errorf("Expected an unverified certificate to be identified by its fingerprint, got %v", client)
+	}
+	request.TLS.VerifiedChains = [][]*x509.Certificate{{certificate}}
+	if client := certificateIdentity(request, certificates); client == nil || client.name != "subject" {
+		t.Errorf("Expected a verified certificate to be identified by its subject, got %v", client)
+	}
+	unknown := []*config.ClientCertificate{{Name: "unknown", Subject: "unknown", Scopes: []string{scopeRead}}}
+	if certificateIdentity(request, unknown) != nil {
+		t.Error("Expected no identity for an unknown certificate")
+	}
+}
+
+func TestClientCa(t *testing.T) {
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "clients-ca.crt")
+	tablet := newTestClientCertificate(t, caFile, "tablet")
+	httpConfig := &config.Http{
+		ApiKeys: []*config.ApiKey{{Name: "dashboard", Hash: HashApiKey("read-key"), Scopes: []string{scopeRead}}},
+		Tls: &config.Tls{
+			CertFile:     filepath.Join(dir, "adapter.crt"),
+			KeyFile:      filepath.Join(dir, "adapter.key"),
+			SelfSigned:   true,
+			ClientCaFile: caFile,
+			ClientCertificates: []*config.ClientCertificate{
+				{Name: "tablet", Subject: "tablet", Scopes: []string{scopeRead}},
+			},
+		},
+	}
+	server := newTestServer(t, httpConfig, newTestGateway(t).Settings())
+	address := serveTls(t, server)
+
+	// With api keys a client certificate is optional.
+	if status, err := get(address, nil, "read-key"); err != nil || status != http.StatusOK {
+		t.Errorf("Expected access with an api key, got %d %v", status, err)
+	}
+	if status, err := get(address, &tablet, ""); err != nil || status != http.StatusOK {
+		t.Errorf("Expected access with a client certificate, got %d %v", status, err)
+	}
+
+	// A reloaded CA no longer accepts the certificates of the previous CA.
+	other := newTestClientCertificate(t, caFile, "tablet")
+	if err := server.ReloadCertificate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := get(address, &tablet, ""); err == nil {
+		t.Error("Expected a certificate of the previous CA to be refused")
+	}
+	if status, err := get(address, &other, ""); err != nil || status != http.StatusOK {
+		t.Errorf("Expected access with a certificate of the reloaded CA, got %d %v", status, err)
+	}
+
+	// Without api keys a client certificate is required.
+	httpConfig.ApiKeys = nil
+	address = serveTls(t, newTestServer(t, httpConfig, newTestGateway(t).Settings()))
+	if _, err := get(address, nil, ""); err == nil {
+		t.Error("Expected a client without certificate to be refused")
+	}
+	if status, err := get(address, &other, ""); err != nil || status != http.StatusOK {
+		t.Errorf("Expected access with a client certificate, got %d %v", status, err)
+	}
+}
+
+// newTestClientCertificate writes a new CA to the given file and returns a client certificate with the given common name
+// that is signed by it.
+func newTestClientCertificate(t *testing.T, caFile string, commonName string) tls.Certificate {
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "clients"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caData, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = writePem(caFile, "CERTIFICATE", caData, 0644); err != nil {
+		t.Fatal(err)
+	}
+	ca, _ := x509.ParseCertificate(caData)
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	data, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{data}, PrivateKey: key}
+}
+
+// serveTls serves the server with https on a random port and returns its address.
+func serveTls(t *testing.T, server *Server) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		_ = server.server.ServeTLS(listener, "", "")
+	}()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.server.Shutdown(ctx)
+	})
+	return listener.Addr().String()
+}
+
+// get requests the devices over a new connection with the given client certificate and api key.
+func get(address string, certificate *tls.Certificate, key string) (int, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: true}
+	if certificate != nil {
+		tlsConfig.Certificates = []tls.Certificate{*certificate}
+	}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig, DisableKeepAlives: true}}
+	request, _ := http.NewRequest("GET", "https://"+address+"/api/v1/devices", nil)
+	if key != "" {
+		request.Header.Set("Authorization", "Bearer "+key)
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	return response.StatusCode, nil
+}
@@ -0,0 +1,259 @@
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"overkiz-adapter/internal/config"
+	"overkiz-adapter/internal/domain"
+	"overkiz-adapter/internal/log"
+	"strings"
+	"time"
+)
+
+const (
+	qos               = 1
+	publishTimeout    = time.Second * 10
+	stateTopic        = "state"
+	setTopic          = "set"
+	availabilityTopic = "availability"
+	payloadOnline     = "online"
+	payloadOffline    = "offline"
+	disconnectQuiet   = 250
+	// messageBufferSize is the number of messages that can wait to be published, before new messages are dropped. It
+	// leaves room for the state and discovery messages of all devices that are published when connecting.
+	messageBufferSize = 1024
+)
+
+// Bridge publishes the devices of the gateways and their states to an MQTT broker and executes the commands that are
+// published to the set topics of the devices.
+type Bridge struct {
+	config   *config.Mqtt
+	gateways []*domain.Overkiz
+	client   paho.Client
+	// messages are published by the bridge itself, so the event listeners and MQTT callbacks never wait for the broker.
+	messages chan *message
+}
+
+// message is a retained message that waits to be published. An empty payload removes the retained message of the
+// topic.
+type message struct {
+	topic   string
+	payload any
+}
+
+type commandPayload struct {
+	Name       string `json:"name"`
+	Parameters []any  `json:"parameters"`
+}
+
+func NewBridge(config *config.Mqtt, gateways ...*domain.Overkiz) *Bridge {
+	return &Bridge{
+		config:   config,
+		gateways: gateways,
+		messages: make(chan *message, messageBufferSize),
+	}
+}
+
+// Run connects to the broker and bridges the devices until the context is done. When the broker is unavailable the
+// connection is retried in the background.
+func (b *Bridge) Run(ctx context.Context) error {
+	options := paho.NewClientOptions().
+		AddBroker(b.config.Broker).
+		SetClientID(b.config.ClientId).
+		SetUsername(b.config.Username).
+		SetPassword(b.config.Password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetWill(b.topic(availabilityTopic), payloadOffline, qos, true).
+		SetOnConnectHandler(b.onConnect).
+		SetConnectionLostHandler(func(_ paho.Client, err error) {
+			log.Warningf("Lost connection with MQTT broker %s: %v", b.config.Broker, err)
+		})
+	b.client = paho.NewClient(options)
+	for _, gateway := range b.gateways {
+		overkiz := gateway
+		overkiz.AddEventListener(func(event *domain.Event) {
+			b.handleEvent(overkiz, event)
+		})
+	}
+	log.Infof("Connecting to MQTT broker %s", b.config.Broker)
+	b.client.Connect()
+	for {
+		select {
+		case <-ctx.Done():
+			if b.client.IsConnected() {
+				b.send(&message{topic: b.topic(availabilityTopic), payload: payloadOffline})
+			}
+			b.client.Disconnect(disconnectQuiet)
+			return nil
+		case m := <-b.messages:
+			b.send(m)
+		}
+	}
+}
+
+func (b *Bridge) onConnect(client paho.Client) {
+	log.Infof("Connected to MQTT broker %s", b.config.Broker)
+	commandTopic := b.topic("+", "+", setTopic)
+	if len(b.gateways) > 1 {
+		commandTopic = b.topic("+", "+", "+", setTopic)
+	}
+	token := client.Subscribe(commandTopic, qos, b.handleCommand)
+	if token.WaitTimeout(publishTimeout) && token.Error() != nil {
+		log.Errorf("Failed to subscribe to MQTT commands: %v", token.Error())
+	}
+	if b.config.HomeAssistant != nil {
+		b.subscribeHomeAssistant(client)
+		b.publishDiscovery()
+	}
+	b.publish(b.topic(availabilityTopic), payloadOnline)
+	for _, overkiz := range b.gateways {
+		for _, device := range overkiz.Devices("") {
+			b.publishDevice(device)
+		}
+	}
+}
+
+func (b *Bridge) handleEvent(overkiz *domain.Overkiz, event *domain.Event) {
+	if !b.client.IsConnected() {
+		return
+	}
+	switch event.Name {
+	case "DeviceStateChangedEvent":
+		device := overkiz.Device(event.DeviceURL)
+		if device != nil {
+			b.publishDevice(device)
+		}
+	case "DeviceCreatedEvent":
+		for _, device := range overkiz.Devices("") {
+			if b.config.HomeAssistant != nil {
+				b.publishDeviceDiscovery(device)
+			}
+			b.publishDevice(device)
+		}
+	case "DeviceRemovedEvent":
+		if event.Device != nil {
+			b.removeDevice(event.Device)
+		}
+	}
+}
+
+// removeDevice removes the retained messages of a device that is removed from its gateway.
+func (b *Bridge) removeDevice(device *domain.Device) {
+	b.publish(b.deviceTopic(device, stateTopic), []byte{})
+	if b.config.HomeAssistant != nil {
+		if topic := b.discoveryTopic(device); topic != "" {
+			b.publish(topic, []byte{})
+		}
+	}
+}
+
+func (b *Bridge) publishDevice(device *domain.Device) {
+	payload, err := json.Marshal(device)
+	if err != nil {
+		log.Errorf("Failed to marshal device %s: %v", device.Label, err)
+		return
+	}
+	b.publish(b.deviceTopic(device, stateTopic), payload)
+}
+
+// publish queues a retained message, it is dropped when too many messages are waiting to be published.
+func (b *Bridge) publish(topic string, payload any) {
+	select {
+	case b.messages <- &message{topic: topic, payload: payload}:
+	default:
+		log.Warningf("Dropped MQTT message on topic %s, too many messages are waiting to be published", topic)
+	}
+}
+
+func (b *Bridge) send(m *message) {
+	token := b.client.Publish(m.topic, qos, true, m.payload)
+	if token.WaitTimeout(publishTimeout) && token.Error() != nil {
+		log.Warningf("Failed to publish to MQTT topic %s: %v", m.topic, token.Error())
+	}
+}
+
+// handleCommand executes the command that is published to the set topic of a device. The payload is either the name
+// of a command, like "open", or a json object with the name and parameters of the command. The command is executed in
+// the background, so the MQTT client is not blocked while the gateway handles it.
+func (b *Bridge) handleCommand(_ paho.Client, message paho.Message) {
+	levels := strings.Split(strings.TrimPrefix(message.Topic(), b.config.TopicPrefix+"/"), "/")
+	gateway := ""
+	if len(b.gateways) > 1 && len(levels) == 4 {
+		gateway, levels = levels[0], levels[1:]
+	}
+	if len(levels) != 3 {
+		return
+	}
+	overkiz, device := b.findDevice(gateway, levels[0], levels[1])
+	if device == nil {
+		log.Warningf("Received MQTT command for unknown device on topic %s", message.Topic())
+		return
+	}
+	command, err := parseCommand(message.Payload())
+	if err != nil {
+		log.Warningf("Received invalid MQTT command on topic %s: %v", message.Topic(), err)
+		return
+	}
+	go func() {
+		execution, err := overkiz.ExecuteDeviceCommand(device, command.Name, command.Parameters)
+		if err != nil {
+			log.Errorf("Failed to execute MQTT command %s on device %s: %v", command.Name, device.Label, err)
+			return
+		}
+		log.Debugf("Executing MQTT command %s on device %s as execution %s", command.Name, device.Label, execution.Id)
+	}()
+}
+
+func parseCommand(payload []byte) (*commandPayload, error) {
+	value := strings.TrimSpace(string(payload))
+	command := &commandPayload{}
+	if strings.HasPrefix(value, "{") {
+		err := json.Unmarshal([]byte(value), command)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		command.Name = value
+	}
+	if command.Name == "" {
+		return nil, fmt.Errorf("no command name")
+	}
+	return command, nil
+}
+
+// findDevice returns the device with the given class and label topic levels. With multiple gateways the device is
+// looked up on the gateway with the given topic level.
+func (b *Bridge) findDevice(gateway string, class string, label string) (*domain.Overkiz, *domain.Device) {
+	for _, overkiz := range b.gateways {
+		if len(b.gateways) > 1 && topicLevel(overkiz.Name()) != gateway {
+			continue
+		}
+		for _, device := range overkiz.Devices(class) {
+			if topicLevel(device.Label) == label {
+				return overkiz, device
+			}
+		}
+	}
+	return nil, nil
+}
+
+// deviceTopic returns the topic of a device. With multiple gateways the name of the gateway of the device is the first
+// level after the prefix, to keep devices with the same class and label apart.
+func (b *Bridge) deviceTopic(device *domain.Device, suffix string) string {
+	if len(b.gateways) > 1 {
+		return b.topic(topicLevel(device.Gateway), topicLevel(device.Class), topicLevel(device.Label), suffix)
+	}
+	return b.topic(topicLevel(device.Class), topicLevel(device.Label), suffix)
+}
+
+func (b *Bridge) topic(levels ...string) string {
+	return b.config.TopicPrefix + "/" + strings.Join(levels, "/")
+}
+
+// topicLevel replaces the characters that are not allowed in a single MQTT topic level.
+func topicLevel(value string) string {
+	return strings.NewReplacer("/", "_", "+", "_", "#", "_").Replace(value)
+}
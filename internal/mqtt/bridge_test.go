@@ -0,0 +1,185 @@
+package mqtt
+
+import (
+	"context"
+	"fmt"
+	mochi "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/mochi-mqtt/server/v2/packets"
+	"net"
+	"overkiz-adapter/internal/config"
+	"overkiz-adapter/internal/domain"
+	"overkiz-adapter/internal/domain/domaintest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBridge(t *testing.T) {
+	gateway := domaintest.NewGateway(t, `[{"label":"Bedroom/1","deviceURL":"io://1234-5678-9012/1","definition":{"uiClass":"RollerShutter"},"states":[{"name":"core:ClosureState","type":1,"value":0}]}]`)
+	broker, address := newBroker(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	overkiz, err := domain.NewOverkiz(gateway.Settings(), ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages := make(chan packets.Packet, 10)
+	err = broker.Subscribe("overkiz/#", 1, func(_ *mochi.Client, _ packets.Subscription, pk packets.Packet) {
+		messages <- pk
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bridge := NewBridge(&config.Mqtt{Broker: "tcp://" + address, ClientId: "test", TopicPrefix: "overkiz"}, overkiz)
+	done := make(chan struct{})
+	go func() {
+		_ = bridge.Run(ctx)
+		close(done)
+	}()
+
+	expectMessages(t, messages, map[string]string{
+		"overkiz/availability":                  "online",
+		"overkiz/RollerShutter/Bedroom_1/state": "\"label\":\"Bedroom/1\"",
+	})
+
+	err = broker.Publish("overkiz/RollerShutter/Bedroom_1/set", []byte(`{"name":"setClosure","parameters":[30]}`), false, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case command := <-gateway.Commands:
+		if !strings.Contains(command, `"commands":[{"name":"setClosure","parameters":[30]}],"deviceURL":"io://1234-5678-9012/1"`) {
+			t.Errorf("Unexpected command %s", command)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("Command not executed")
+	}
+
+	cancel()
+	<-done
+}
+
+func TestBridgeMultipleGateways(t *testing.T) {
+	devices := `[{"label":"Bedroom","deviceURL":"io://1234-5678-9012/1","definition":{"uiClass":"RollerShutter"}}]`
+	house, garage := domaintest.NewGateway(t, devices), domaintest.NewGateway(t, devices)
+	broker, address := newBroker(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	gateways := make([]*domain.Overkiz, 0)
+	for name, gateway := range map[string]*domaintest.Gateway{"house": house, "garage": garage} {
+		settings := gateway.Settings()
+		settings.Name = name
+		overkiz, err := domain.NewOverkiz(settings, ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gateways = append(gateways, overkiz)
+	}
+
+	messages := make(chan packets.Packet, 100)
+	err := broker.Subscribe("#", 1, func(_ *mochi.Client, _ packets.Subscription, pk packets.Packet) {
+		messages <- pk
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bridge := NewBridge(&config.Mqtt{Broker: "tcp://" + address, ClientId: "test", TopicPrefix: "overkiz",
+		HomeAssistant: &config.HomeAssistant{DiscoveryPrefix: "homeassistant"}}, gateways...)
+	done := make(chan struct{})
+	go func() {
+		_ = bridge.Run(ctx)
+		close(done)
+	}()
+	expectMessages(t, messages, map[string]string{
+		"overkiz/house/RollerShutter/Bedroom/state":  `"gateway":"house"`,
+		"overkiz/garage/RollerShutter/Bedroom/state": `"gateway":"garage"`,
+	})
+
+	err = broker.Publish("overkiz/garage/RollerShutter/Bedroom/set", []byte("close"), false, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-garage.Commands:
+	case <-time.After(time.Second * 5):
+		t.Fatal("Command not executed")
+	}
+	if len(house.Commands) != 0 {
+		t.Error("Expected the command to be executed on the garage gateway only")
+	}
+
+	// The retained messages of a removed device are cleared.
+	overkiz := gateways[0]
+	device := overkiz.Devices("")[0]
+	bridge.handleEvent(overkiz, &domain.Event{Name: "DeviceRemovedEvent", DeviceURL: device.DeviceURL, Device: device})
+	expectMessages(t, messages, map[string]string{
+		fmt.Sprintf("overkiz/%s/RollerShutter/Bedroom/state", overkiz.Name()):                         "",
+		fmt.Sprintf("homeassistant/cover/test/overkiz_%s_io_1234-5678-9012_1/config", overkiz.Name()): "",
+	})
+
+	cancel()
+	<-done
+}
+
+// expectMessages waits for messages on the given topics that contain the given content. An empty content expects an
+// empty payload, which removes the retained message of the topic.
+func expectMessages(t *testing.T, messages <-chan packets.Packet, expected map[string]string) {
+	for len(expected) > 0 {
+		select {
+		case pk := <-messages:
+			content, ok := expected[pk.TopicName]
+			if !ok || (content == "") != (len(pk.Payload) == 0) {
+				continue
+			}
+			if !strings.Contains(string(pk.Payload), content) {
+				t.Errorf("Unexpected payload %s on topic %s", pk.Payload, pk.TopicName)
+			}
+			delete(expected, pk.TopicName)
+		case <-time.After(time.Second * 5):
+			t.Fatalf("Messages not published on topics %v", expected)
+		}
+	}
+}
+
+func TestParseCommand(t *testing.T) {
+	command, err := parseCommand([]byte(" open "))
+	if err != nil || command.Name != "open" || len(command.Parameters) != 0 {
+		t.Errorf("Unexpected command %v: %v", command, err)
+	}
+	command, err = parseCommand([]byte(`{"name":"setClosure","parameters":[30]}`))
+	if err != nil || command.Name != "setClosure" || len(command.Parameters) != 1 {
+		t.Errorf("Unexpected command %v: %v", command, err)
+	}
+	_, err = parseCommand([]byte(`{"parameters":[30]}`))
+	if err == nil {
+		t.Error("Expected an error for a command without a name")
+	}
+}
+
+func newBroker(t *testing.T) (*mochi.Server, string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	_ = listener.Close()
+
+	broker := mochi.New(&mochi.Options{InlineClient: true})
+	_ = broker.AddHook(new(auth.AllowHook), nil)
+	err = broker.AddListener(listeners.NewTCP(listeners.Config{ID: "test", Address: address}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		_ = broker.Serve()
+	}()
+	t.Cleanup(func() {
+		_ = broker.Close()
+	})
+	return broker, address
+}
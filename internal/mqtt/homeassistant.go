@@ -0,0 +1,182 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"fmt"
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"overkiz-adapter/internal/domain"
+	"overkiz-adapter/internal/log"
+	"regexp"
+)
+
+var nonIdCharacters = regexp.MustCompile("[^a-zA-Z0-9_-]+")
+
+// cover describes how a device class is mapped to a Home Assistant cover.
+type cover struct {
+	deviceClass string
+	// positionState is the state of the device that holds its position.
+	positionState string
+	// inverted is true when a position of 100 in Overkiz means closed, where Home Assistant uses 100 for open.
+	inverted bool
+	// positionCommand is the command that sets the position of the device.
+	positionCommand string
+}
+
+// sensor describes how a device class is mapped to a Home Assistant sensor.
+type sensor struct {
+	deviceClass string
+	state       string
+	unit        string
+}
+
+var covers = map[string]*cover{
+	"RollerShutter":  {deviceClass: "shutter", positionState: "core:ClosureState", inverted: true, positionCommand: "setClosure"},
+	"ExteriorScreen": {deviceClass: "shade", positionState: "core:ClosureState", inverted: true, positionCommand: "setClosure"},
+	"Awning":         {deviceClass: "awning", positionState: "core:DeploymentState", positionCommand: "setDeployment"},
+}
+
+var sensors = map[string]*sensor{
+	"TemperatureSensor": {deviceClass: "temperature", state: "core:TemperatureState", unit: "°C"},
+	"HumiditySensor":    {deviceClass: "humidity", state: "core:RelativeHumidityState", unit: "%"},
+	"LightSensor":       {deviceClass: "illuminance", state: "core:LuminanceState", unit: "lx"},
+	"ContactSensor":     {state: "core:ContactState"},
+	"OccupancySensor":   {state: "core:OccupancyState"},
+	"SmokeSensor":       {state: "core:SmokeState"},
+	"WindowHandle":      {state: "core:ThreeWayHandleDirectionState"},
+}
+
+type discoveryDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+	Model        string   `json:"model"`
+}
+
+type discoveryConfig struct {
+	Name                string           `json:"name"`
+	UniqueId            string           `json:"unique_id"`
+	Device              *discoveryDevice `json:"device"`
+	AvailabilityTopic   string           `json:"availability_topic"`
+	CommandTopic        string           `json:"command_topic,omitempty"`
+	StateTopic          string           `json:"state_topic,omitempty"`
+	DeviceClass         string           `json:"device_class,omitempty"`
+	ValueTemplate       string           `json:"value_template,omitempty"`
+	UnitOfMeasurement   string           `json:"unit_of_measurement,omitempty"`
+	PayloadOpen         string           `json:"payload_open,omitempty"`
+	PayloadClose        string           `json:"payload_close,omitempty"`
+	PayloadStop         string           `json:"payload_stop,omitempty"`
+	PositionTopic       string           `json:"position_topic,omitempty"`
+	PositionTemplate    string           `json:"position_template,omitempty"`
+	SetPositionTopic    string           `json:"set_position_topic,omitempty"`
+	SetPositionTemplate string           `json:"set_position_template,omitempty"`
+	PayloadOn           string           `json:"payload_on,omitempty"`
+	PayloadOff          string           `json:"payload_off,omitempty"`
+	StateValueTemplate  string           `json:"state_value_template,omitempty"`
+}
+
+// subscribeHomeAssistant republishes the discovery messages every time Home Assistant announces that it is online
+// again on the status topic below the discovery prefix.
+func (b *Bridge) subscribeHomeAssistant(client paho.Client) {
+	token := client.Subscribe(b.config.HomeAssistant.DiscoveryPrefix+"/status", qos, func(_ paho.Client, message paho.Message) {
+		if string(message.Payload()) == payloadOnline {
+			// The messages are published in the background, the MQTT client must not be blocked by its callbacks.
+			go b.publishDiscovery()
+		}
+	})
+	if token.WaitTimeout(publishTimeout) && token.Error() != nil {
+		log.Errorf("Failed to subscribe to the Home Assistant status: %v", token.Error())
+	}
+}
+
+// publishDiscovery publishes the Home Assistant discovery messages of all devices that can be mapped to a Home
+// Assistant entity.
+func (b *Bridge) publishDiscovery() {
+	for _, overkiz := range b.gateways {
+		for _, device := range overkiz.Devices("") {
+			b.publishDeviceDiscovery(device)
+		}
+	}
+}
+
+func (b *Bridge) publishDeviceDiscovery(device *domain.Device) {
+	component, discovery := b.newDiscoveryConfig(device)
+	if discovery == nil {
+		log.Tracef("Device %s of class %s is not supported by Home Assistant", device.Label, device.Class)
+		return
+	}
+	payload, err := json.Marshal(discovery)
+	if err != nil {
+		log.Errorf("Failed to marshal discovery message of device %s: %v", device.Label, err)
+		return
+	}
+	b.publish(b.componentTopic(component, discovery), payload)
+}
+
+// discoveryTopic returns the topic of the discovery message of the device, or an empty string when the device is not
+// supported by Home Assistant.
+func (b *Bridge) discoveryTopic(device *domain.Device) string {
+	component, discovery := b.newDiscoveryConfig(device)
+	if discovery == nil {
+		return ""
+	}
+	return b.componentTopic(component, discovery)
+}
+
+func (b *Bridge) componentTopic(component string, discovery *discoveryConfig) string {
+	return fmt.Sprintf("%s/%s/%s/%s/config", b.config.HomeAssistant.DiscoveryPrefix, component, b.config.ClientId, discovery.UniqueId)
+}
+
+// newDiscoveryConfig maps the device to a Home Assistant entity. The component of the entity is returned together with
+// its discovery configuration, or nil when the device cannot be mapped.
+func (b *Bridge) newDiscoveryConfig(device *domain.Device) (string, *discoveryConfig) {
+	uniqueId := nonIdCharacters.ReplaceAllString(fmt.Sprintf("overkiz_%s_%s", device.Gateway, device.DeviceURL), "_")
+	discovery := &discoveryConfig{
+		Name:     device.Label,
+		UniqueId: uniqueId,
+		Device: &discoveryDevice{
+			Identifiers:  []string{uniqueId},
+			Name:         device.Label,
+			Manufacturer: "Overkiz",
+			Model:        device.Class,
+		},
+		AvailabilityTopic: b.topic(availabilityTopic),
+		StateTopic:        b.deviceTopic(device, stateTopic),
+	}
+	if c, ok := covers[device.Class]; ok {
+		discovery.DeviceClass = c.deviceClass
+		discovery.CommandTopic = b.deviceTopic(device, setTopic)
+		discovery.PayloadOpen = "open"
+		discovery.PayloadClose = "close"
+		discovery.PayloadStop = "stop"
+		discovery.StateTopic = ""
+		discovery.PositionTopic = b.deviceTopic(device, stateTopic)
+		discovery.SetPositionTopic = b.deviceTopic(device, setTopic)
+		if c.inverted {
+			discovery.PositionTemplate = stateTemplate(c.positionState, "100 - s.value")
+			discovery.SetPositionTemplate = fmt.Sprintf(`{"name":"%s","parameters":[{{ 100 - position }}]}`, c.positionCommand)
+		} else {
+			discovery.PositionTemplate = stateTemplate(c.positionState, "s.value")
+			discovery.SetPositionTemplate = fmt.Sprintf(`{"name":"%s","parameters":[{{ position }}]}`, c.positionCommand)
+		}
+		return "cover", discovery
+	}
+	if device.Class == "Light" {
+		discovery.CommandTopic = b.deviceTopic(device, setTopic)
+		discovery.PayloadOn = "on"
+		discovery.PayloadOff = "off"
+		discovery.StateValueTemplate = stateTemplate("core:OnOffState", "s.value")
+		return "light", discovery
+	}
+	if s, ok := sensors[device.Class]; ok {
+		discovery.DeviceClass = s.deviceClass
+		discovery.UnitOfMeasurement = s.unit
+		discovery.ValueTemplate = stateTemplate(s.state, "s.value")
+		return "sensor", discovery
+	}
+	return "", nil
+}
+
+// stateTemplate creates a template that extracts the value of a single state from the published states of a device.
+func stateTemplate(state string, expression string) string {
+	return fmt.Sprintf("{%% for s in value_json.states if s.name == '%s' %%}{{ %s }}{%% endfor %%}", state, expression)
+}
@@ -0,0 +1,81 @@
+package mqtt
+
+import (
+	"context"
+	mochi "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/packets"
+	"overkiz-adapter/internal/config"
+	"overkiz-adapter/internal/domain"
+	"overkiz-adapter/internal/domain/domaintest"
+	"testing"
+)
+
+func TestNewDiscoveryConfig(t *testing.T) {
+	bridge := NewBridge(&config.Mqtt{ClientId: "adapter", TopicPrefix: "overkiz", HomeAssistant: &config.HomeAssistant{DiscoveryPrefix: "homeassistant"}})
+
+	component, discovery := bridge.newDiscoveryConfig(&domain.Device{Label: "Bedroom", Class: "RollerShutter", DeviceURL: "io://1234-5678-9012/1", Gateway: "house"})
+	if component != "cover" {
+		t.Fatalf("Unexpected component %s", component)
+	}
+	if discovery.UniqueId != "overkiz_house_io_1234-5678-9012_1" {
+		t.Errorf("Unexpected unique id %s", discovery.UniqueId)
+	}
+	if discovery.CommandTopic != "overkiz/RollerShutter/Bedroom/set" || discovery.PositionTopic != "overkiz/RollerShutter/Bedroom/state" {
+		t.Errorf("Unexpected topics %s and %s", discovery.CommandTopic, discovery.PositionTopic)
+	}
+	if discovery.SetPositionTemplate != `{"name":"setClosure","parameters":[{{ 100 - position }}]}` {
+		t.Errorf("Unexpected set position template %s", discovery.SetPositionTemplate)
+	}
+
+	component, discovery = bridge.newDiscoveryConfig(&domain.Device{Label: "Porch", Class: "Light", DeviceURL: "io://1234-5678-9012/2"})
+	if component != "light" || discovery.PayloadOn != "on" {
+		t.Errorf("Unexpected light discovery %s %v", component, discovery)
+	}
+
+	component, discovery = bridge.newDiscoveryConfig(&domain.Device{Label: "Garden", Class: "TemperatureSensor", DeviceURL: "io://1234-5678-9012/3"})
+	if component != "sensor" || discovery.DeviceClass != "temperature" || discovery.StateTopic != "overkiz/TemperatureSensor/Garden/state" {
+		t.Errorf("Unexpected sensor discovery %s %v", component, discovery)
+	}
+
+	_, discovery = bridge.newDiscoveryConfig(&domain.Device{Label: "Box", Class: "ProtocolGateway", DeviceURL: "internal://1234-5678-9012/pod/0"})
+	if discovery != nil {
+		t.Errorf("Unexpected discovery for an unsupported device %v", discovery)
+	}
+}
+
+func TestHomeAssistantStatus(t *testing.T) {
+	gateway := domaintest.NewGateway(t, `[{"label":"Bedroom","deviceURL":"io://1234-5678-9012/1","definition":{"uiClass":"RollerShutter"}}]`)
+	broker, address := newBroker(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	overkiz, err := domain.NewOverkiz(gateway.Settings(), ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := make(chan packets.Packet, 100)
+	err = broker.Subscribe("ha/cover/#", 1, func(_ *mochi.Client, _ packets.Subscription, pk packets.Packet) {
+		messages <- pk
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bridge := NewBridge(&config.Mqtt{Broker: "tcp://" + address, ClientId: "test", TopicPrefix: "overkiz",
+		HomeAssistant: &config.HomeAssistant{DiscoveryPrefix: "ha"}}, overkiz)
+	done := make(chan struct{})
+	go func() {
+		_ = bridge.Run(ctx)
+		close(done)
+	}()
+	topic := "ha/cover/test/overkiz_test_io_1234-5678-9012_1/config"
+	expectMessages(t, messages, map[string]string{topic: `"name":"Bedroom"`})
+
+	// The discovery messages are published again when Home Assistant comes online on the status topic of its prefix.
+	err = broker.Publish("ha/status", []byte(payloadOnline), false, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectMessages(t, messages, map[string]string{topic: `"name":"Bedroom"`})
+
+	cancel()
+	<-done
+}
@@ -0,0 +1,357 @@
+package rules
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"overkiz-adapter/internal/actions"
+	"overkiz-adapter/internal/config"
+	"overkiz-adapter/internal/domain"
+	"overkiz-adapter/internal/log"
+	"overkiz-adapter/internal/schedule"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrUnknownRule    = errors.New("unknown rule")
+	ErrUnknownWebhook = errors.New("unknown webhook")
+)
+
+// Engine triggers the configured rules and executes their actions on the gateways.
+type Engine struct {
+	rules    []*rule
+	gateways []*domain.Overkiz
+	executor *actions.Executor
+	timezone *time.Location
+	lock     sync.Mutex
+	stopped  bool
+	running  sync.WaitGroup
+}
+
+type rule struct {
+	config     *config.Rule
+	enabled    atomic.Bool
+	schedule   schedule.Schedule
+	tracker    *domain.ConditionTracker
+	conditions []*condition
+	lock       sync.Mutex
+	nextRun    time.Time
+	triggered  time.Time
+	lastError  string
+}
+
+type condition struct {
+	config *config.RuleCondition
+	after  schedule.Schedule
+	before schedule.Schedule
+}
+
+// Status is the state of a rule as shown by the api.
+type Status struct {
+	Name          string              `json:"name"`
+	Enabled       bool                `json:"enabled"`
+	Trigger       *config.RuleTrigger `json:"trigger"`
+	NextRun       *time.Time          `json:"next_run,omitempty"`
+	LastTriggered *time.Time          `json:"last_triggered,omitempty"`
+	LastError     string              `json:"last_error,omitempty"`
+}
+
+// NewEngine creates an engine for the given rules. An error is returned when a rule has an invalid schedule or refers
+// to a device or scene that does not exist.
+func NewEngine(rules []*config.Rule, location *config.Location, gateways ...*domain.Overkiz) (*Engine, error) {
+	timezone, err := schedule.Timezone(location)
+	if err != nil {
+		return nil, err
+	}
+	e := &Engine{
+		gateways: gateways,
+		executor: actions.NewExecutor(gateways...),
+		timezone: timezone,
+	}
+	var errs []error
+	for _, ruleConfig := range rules {
+		r, err := e.newRule(ruleConfig, location)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("rule %s: %w", ruleConfig.Name, err))
+			continue
+		}
+		e.rules = append(e.rules, r)
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return e, nil
+}
+
+func (e *Engine) newRule(ruleConfig *config.Rule, location *config.Location) (*rule, error) {
+	r := &rule{
+		config: ruleConfig,
+	}
+	r.enabled.Store(!ruleConfig.Disabled)
+	var err error
+	trigger := ruleConfig.Trigger
+	if trigger.Schedule != "" {
+		r.schedule, err = schedule.Parse(trigger.Schedule, location)
+		if err != nil {
+			return nil, err
+		}
+	} else if trigger.Device != "" || trigger.Class != "" {
+		r.tracker = domain.NewConditionTracker(&trigger.StateCondition)
+	}
+	for _, conditionConfig := range ruleConfig.Conditions {
+		c := &condition{
+			config: conditionConfig,
+		}
+		if conditionConfig.After != "" {
+			c.after, err = schedule.Parse(conditionConfig.After, location)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if conditionConfig.Before != "" {
+			c.before, err = schedule.Parse(conditionConfig.Before, location)
+			if err != nil {
+				return nil, err
+			}
+		}
+		r.conditions = append(r.conditions, c)
+	}
+	for _, action := range ruleConfig.Actions {
+		err = e.executor.Validate(action)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Run triggers the rules on the events of the gateways and their schedules until the context is done. Running actions
+// are awaited before returning.
+func (e *Engine) Run(ctx context.Context) error {
+	for _, gateway := range e.gateways {
+		overkiz := gateway
+		// Devices that already match when starting should not trigger the rule on their next state change.
+		for _, device := range overkiz.Devices("") {
+			for _, r := range e.rules {
+				if r.tracker != nil && matchesGateway(r.config.Trigger.Gateway, overkiz) {
+					r.tracker.Update(overkiz, device)
+				}
+			}
+		}
+		overkiz.AddEventListener(func(event *domain.Event) {
+			e.handleEvent(overkiz, event)
+		})
+	}
+	for _, r := range e.rules {
+		if r.schedule != nil {
+			e.running.Add(1)
+			go e.runSchedule(ctx, r)
+		}
+	}
+	<-ctx.Done()
+	e.lock.Lock()
+	e.stopped = true
+	e.lock.Unlock()
+	e.running.Wait()
+	return nil
+}
+
+func (e *Engine) handleEvent(overkiz *domain.Overkiz, event *domain.Event) {
+	if event.Name != "DeviceStateChangedEvent" {
+		return
+	}
+	device := overkiz.Device(event.DeviceURL)
+	if device == nil {
+		return
+	}
+	for _, r := range e.rules {
+		if r.tracker == nil || !matchesGateway(r.config.Trigger.Gateway, overkiz) {
+			continue
+		}
+		if r.tracker.Update(overkiz, device) {
+			e.trigger(r, "device "+device.Label)
+		}
+	}
+}
+
+func (e *Engine) runSchedule(ctx context.Context, r *rule) {
+	defer e.running.Done()
+	for {
+		next := r.schedule.Next(time.Now())
+		r.setNextRun(next)
+		if next.IsZero() {
+			log.Warningf("Schedule %s of rule %s has no next run", r.config.Trigger.Schedule, r.config.Name)
+			return
+		}
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+			e.trigger(r, "schedule "+r.config.Trigger.Schedule)
+		}
+	}
+}
+
+// TriggerWebhook triggers the rules with the given webhook trigger. The names of the enabled rules that are triggered
+// are returned.
+func (e *Engine) TriggerWebhook(name string) ([]string, error) {
+	found := false
+	triggered := make([]string, 0)
+	for _, r := range e.rules {
+		if !strings.EqualFold(r.config.Trigger.Webhook, name) {
+			continue
+		}
+		found = true
+		if r.enabled.Load() {
+			e.trigger(r, "webhook "+name)
+			triggered = append(triggered, r.config.Name)
+		}
+	}
+	if !found {
+		return nil, ErrUnknownWebhook
+	}
+	return triggered, nil
+}
+
+// trigger executes the actions of the rule in the background when the rule is enabled and its conditions are met.
+func (e *Engine) trigger(r *rule, reason string) {
+	if !r.enabled.Load() {
+		return
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.stopped {
+		return
+	}
+	e.running.Add(1)
+	go func() {
+		defer e.running.Done()
+		if !e.conditionsMet(r, time.Now()) {
+			log.Debugf("Conditions of rule %s triggered by %s are not met", r.config.Name, reason)
+			return
+		}
+		log.Infof("Executing rule %s triggered by %s", r.config.Name, reason)
+		err := e.executor.ExecuteAll(r.config.Actions)
+		if err != nil {
+			log.Errorf("Failed to execute rule %s: %v", r.config.Name, err)
+		}
+		r.setTriggered(err)
+	}()
+}
+
+func (e *Engine) conditionsMet(r *rule, now time.Time) bool {
+	for _, c := range r.conditions {
+		if !e.stateConditionMet(c.config) || !c.timeConditionMet(now.In(e.timezone)) {
+			return false
+		}
+	}
+	return true
+}
+
+// stateConditionMet returns true when a device matches the state condition, or when the condition has no state
+// condition.
+func (e *Engine) stateConditionMet(c *config.RuleCondition) bool {
+	if c.Device == "" && c.Class == "" && c.State == "" {
+		return true
+	}
+	for _, overkiz := range e.executor.Gateways(c.Gateway) {
+		for _, device := range overkiz.Devices("") {
+			if domain.MatchesCondition(&c.StateCondition, device) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// timeConditionMet returns true when the time is after and/or before the times of the condition on the same day. When
+// the after time is later than the before time, the period spans midnight.
+func (c *condition) timeConditionMet(now time.Time) bool {
+	if c.after == nil && c.before == nil {
+		return true
+	}
+	startOfDay := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()).Add(-time.Nanosecond)
+	var after, before time.Time
+	if c.after != nil {
+		after = c.after.Next(startOfDay)
+	}
+	if c.before != nil {
+		before = c.before.Next(startOfDay)
+	}
+	switch {
+	case c.before == nil:
+		return !now.Before(after)
+	case c.after == nil:
+		return now.Before(before)
+	case after.Before(before):
+		return !now.Before(after) && now.Before(before)
+	default:
+		return !now.Before(after) || now.Before(before)
+	}
+}
+
+func matchesGateway(name string, overkiz *domain.Overkiz) bool {
+	return name == "" || name == overkiz.Name()
+}
+
+// Rules returns the status of all rules.
+func (e *Engine) Rules() []*Status {
+	result := make([]*Status, 0, len(e.rules))
+	for _, r := range e.rules {
+		result = append(result, r.status())
+	}
+	return result
+}
+
+// SetEnabled enables or disables the rule with the given name. The status of the rule is returned.
+func (e *Engine) SetEnabled(name string, enabled bool) (*Status, error) {
+	for _, r := range e.rules {
+		if strings.EqualFold(r.config.Name, name) {
+			r.enabled.Store(enabled)
+			log.Infof("Rule %s enabled: %t", r.config.Name, enabled)
+			return r.status(), nil
+		}
+	}
+	return nil, ErrUnknownRule
+}
+
+func (r *rule) status() *Status {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	status := &Status{
+		Name:      r.config.Name,
+		Enabled:   r.enabled.Load(),
+		Trigger:   r.config.Trigger,
+		LastError: r.lastError,
+	}
+	if !r.nextRun.IsZero() {
+		nextRun := r.nextRun
+		status.NextRun = &nextRun
+	}
+	if !r.triggered.IsZero() {
+		triggered := r.triggered
+		status.LastTriggered = &triggered
+	}
+	return status
+}
+
+func (r *rule) setNextRun(next time.Time) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.nextRun = next
+}
+
+func (r *rule) setTriggered(err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.triggered = time.Now()
+	r.lastError = ""
+	if err != nil {
+		r.lastError = err.Error()
+	}
+}
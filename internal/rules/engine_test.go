@@ -0,0 +1,191 @@
+package rules
+
+import (
+	"context"
+	"errors"
+	"overkiz-adapter/internal/config"
+	"overkiz-adapter/internal/domain"
+	"overkiz-adapter/internal/domain/domaintest"
+	"overkiz-adapter/internal/schedule"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimeCondition(t *testing.T) {
+	tests := []struct {
+		after    string
+		before   string
+		now      string
+		expected bool
+	}{
+		{after: "08:00", now: "09:00", expected: true},
+		{after: "08:00", now: "07:59", expected: false},
+		{before: "08:00", now: "07:59", expected: true},
+		{before: "08:00", now: "08:00", expected: false},
+		{after: "08:00", before: "22:00", now: "12:00", expected: true},
+		{after: "08:00", before: "22:00", now: "23:00", expected: false},
+		{after: "22:00", before: "06:00", now: "23:00", expected: true},
+		{after: "22:00", before: "06:00", now: "05:00", expected: true},
+		{after: "22:00", before: "06:00", now: "12:00", expected: false},
+	}
+	for _, test := range tests {
+		c := &condition{}
+		if test.after != "" {
+			c.after, _ = schedule.Parse(test.after, &config.Location{Timezone: "UTC"})
+		}
+		if test.before != "" {
+			c.before, _ = schedule.Parse(test.before, &config.Location{Timezone: "UTC"})
+		}
+		now, _ := time.Parse("2006-01-02 15:04", "2024-06-14 "+test.now)
+		if c.timeConditionMet(now) != test.expected {
+			t.Errorf("Expected %t for %s between %s and %s", test.expected, test.now, test.after, test.before)
+		}
+	}
+}
+
+func TestUnknownDevice(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rules := []*config.Rule{{
+		Name:    "wake up",
+		Trigger: &config.RuleTrigger{Webhook: "alarm"},
+		Actions: []*config.Action{{Device: "Kitchen", Command: "open"}},
+	}}
+
+	// Without loaded devices the device cannot be validated when starting.
+	unreachable := &domain.Gateway{Name: "test", Host: "127.0.0.1", Port: 1, PollInterval: time.Minute, RequestTimeout: time.Second,
+		RetryInterval: time.Minute, MaxRetryInterval: time.Minute, InsecureSkipVerify: true}
+	overkiz, err := domain.NewOverkiz(unreachable, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewEngine(rules, &config.Location{Timezone: "UTC"}, overkiz)
+	if err != nil {
+		t.Errorf("Expected the device not to be validated without loaded devices, got %v", err)
+	}
+
+	gateway := domaintest.NewGateway(t, `[{"label":"Bedroom","deviceURL":"io://1234-5678-9012/1","definition":{"uiClass":"RollerShutter"}}]`)
+	overkiz, err = domain.NewOverkiz(gateway.Settings(), ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewEngine(rules, &config.Location{Timezone: "UTC"}, overkiz)
+	if err == nil {
+		t.Error("Expected an error for an unknown device")
+	}
+}
+
+const testDevices = `[{"label":"Bedroom","deviceURL":"io://1234-5678-9012/1","definition":{"uiClass":"RollerShutter"},` +
+	`"states":[{"name":"core:ClosureState","type":1,"value":0}]},` +
+	`{"label":"Living room","deviceURL":"io://1234-5678-9012/2","definition":{"uiClass":"Light"}}]`
+
+func TestDeviceTrigger(t *testing.T) {
+	closed := 100.0
+	rules := []*config.Rule{
+		{
+			Name:    "any change",
+			Trigger: &config.RuleTrigger{StateCondition: domain.StateCondition{Device: "Bedroom"}},
+			Actions: []*config.Action{{Class: "Light", Command: "on"}},
+		},
+		{
+			Name:    "closed",
+			Trigger: &config.RuleTrigger{StateCondition: domain.StateCondition{Class: "RollerShutter", State: "core:ClosureState", Value: closed}},
+			Actions: []*config.Action{{Device: "Living room", Command: "off"}},
+		},
+		{
+			Name:     "disabled",
+			Disabled: true,
+			Trigger:  &config.RuleTrigger{StateCondition: domain.StateCondition{Device: "Bedroom"}},
+			Actions:  []*config.Action{{Class: "Light", Command: "setIntensity", Parameters: []any{50}}},
+		},
+	}
+	// The first fetch returns no events, so the engine is running when the state changes.
+	gateway, _ := newTestEngine(t, rules, `[]`, `[{"name":"DeviceStateChangedEvent","deviceURL":"io://1234-5678-9012/1",`+
+		`"deviceStates":[{"name":"core:ClosureState","type":1,"value":"100"}]}]`)
+	commands := make(map[string]bool)
+	for len(commands) < 2 {
+		select {
+		case command := <-gateway.Commands:
+			for _, name := range []string{"on", "off", "setIntensity"} {
+				if strings.Contains(command, `"name":"`+name+`"`) {
+					commands[name] = true
+				}
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected the rules to be triggered, got %v", commands)
+		}
+	}
+	if !commands["on"] || !commands["off"] {
+		t.Errorf("Expected the enabled rules to be executed, got %v", commands)
+	}
+	select {
+	case command := <-gateway.Commands:
+		t.Errorf("Expected the disabled rule not to be executed, got %s", command)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestTriggerWebhook(t *testing.T) {
+	rules := []*config.Rule{
+		{
+			Name:    "doorbell",
+			Trigger: &config.RuleTrigger{Webhook: "doorbell"},
+			Actions: []*config.Action{{Device: "Living room", Command: "on"}},
+		},
+		{
+			Name:    "camera",
+			Trigger: &config.RuleTrigger{Webhook: "Doorbell"},
+			Actions: []*config.Action{{Device: "Bedroom", Command: "open"}},
+		},
+	}
+	gateway, engine := newTestEngine(t, rules)
+	if _, err := engine.SetEnabled("Camera", false); err != nil {
+		t.Fatal(err)
+	}
+	triggered, err := engine.TriggerWebhook("DOORBELL")
+	if err != nil || len(triggered) != 1 || triggered[0] != "doorbell" {
+		t.Fatalf("Expected the enabled rule to be triggered, got %v %v", triggered, err)
+	}
+	select {
+	case command := <-gateway.Commands:
+		if !strings.Contains(command, `"deviceURL":"io://1234-5678-9012/2"`) {
+			t.Errorf("Expected the living room to be switched on, got %s", command)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the actions of the rule to be executed")
+	}
+	if _, err = engine.TriggerWebhook("unknown"); !errors.Is(err, ErrUnknownWebhook) {
+		t.Errorf("Expected an unknown webhook, got %v", err)
+	}
+	if _, err = engine.SetEnabled("unknown", true); !errors.Is(err, ErrUnknownRule) {
+		t.Errorf("Expected an unknown rule, got %v", err)
+	}
+}
+
+// newTestEngine runs an engine with the given rules on a fake gateway with the test devices, the given json events are
+// returned by the consecutive event fetches.
+func newTestEngine(t *testing.T, rules []*config.Rule, events ...string) (*domaintest.Gateway, *Engine) {
+	gateway := domaintest.NewGateway(t, testDevices, events...)
+	ctx, cancel := context.WithCancel(context.Background())
+	overkiz, err := domain.NewOverkiz(gateway.Settings(), ctx)
+	if err != nil {
+		cancel()
+		t.Fatal(err)
+	}
+	engine, err := NewEngine(rules, &config.Location{Timezone: "UTC"}, overkiz)
+	if err != nil {
+		cancel()
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		_ = engine.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return gateway, engine
+}
@@ -0,0 +1,157 @@
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSchedule is a schedule defined by a cron expression with the fields minute, hour, day of month, month and day
+// of week. Every field is a set of values stored as bits.
+type cronSchedule struct {
+	minutes     uint64
+	hours       uint64
+	daysOfMonth uint64
+	months      uint64
+	daysOfWeek  uint64
+	// anyDayOfMonth and anyDayOfWeek are true when the field starts with a *, like * or */2, which makes it unrestricted
+	// like in Vixie cron. When only one of the fields is restricted that field decides, when both are restricted a day
+	// matches when either field matches.
+	anyDayOfMonth bool
+	anyDayOfWeek  bool
+	timezone      *time.Location
+}
+
+type cronField struct {
+	name string
+	min  int
+	max  int
+}
+
+var cronFields = []cronField{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12},
+	{name: "day of week", min: 0, max: 7},
+}
+
+func parseCron(spec string, timezone *time.Location) (*cronSchedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("cron expression %s must have %d fields", spec, len(cronFields))
+	}
+	values := make([]uint64, len(fields))
+	for ix, field := range fields {
+		bits, err := parseCronField(field, cronFields[ix])
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression %s: %w", spec, err)
+		}
+		values[ix] = bits
+	}
+	// Sunday can be written as 0 or 7.
+	if values[4]&(1<<7) != 0 {
+		values[4] |= 1
+	}
+	return &cronSchedule{
+		minutes:       values[0],
+		hours:         values[1],
+		daysOfMonth:   values[2],
+		months:        values[3],
+		daysOfWeek:    values[4],
+		anyDayOfMonth: strings.HasPrefix(fields[2], "*"),
+		anyDayOfWeek:  strings.HasPrefix(fields[4], "*"),
+		timezone:      timezone,
+	}, nil
+}
+
+// parseCronField parses a comma separated list of values, ranges like 1-5 and steps like */15 or 0-30/10.
+func parseCronField(value string, field cronField) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(value, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepPart)
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %s of %s", stepPart, field.name)
+			}
+		}
+		start, end := field.min, field.max
+		if rangePart != "*" {
+			from, to, isRange := strings.Cut(rangePart, "-")
+			var err error
+			start, err = strconv.Atoi(from)
+			if err != nil {
+				return 0, fmt.Errorf("invalid %s %s", field.name, from)
+			}
+			end = start
+			if isRange {
+				end, err = strconv.Atoi(to)
+				if err != nil {
+					return 0, fmt.Errorf("invalid %s %s", field.name, to)
+				}
+			} else if hasStep {
+				end = field.max
+			}
+		}
+		if start < field.min || end > field.max || start > end {
+			return 0, fmt.Errorf("%s %s out of range %d-%d", field.name, rangePart, field.min, field.max)
+		}
+		for i := start; i <= end; i += step {
+			bits |= 1 << i
+		}
+	}
+	return bits, nil
+}
+
+func (c *cronSchedule) Next(after time.Time) time.Time {
+	t := after.In(c.timezone).Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(0, 0, maxSearchDays)
+	for t.Before(limit) {
+		if c.months&(1<<int(t.Month())) == 0 {
+			t = advance(t, time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, c.timezone))
+			continue
+		}
+		if !c.matchesDay(t) {
+			t = advance(t, time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, c.timezone))
+			continue
+		}
+		if c.hours&(1<<t.Hour()) == 0 {
+			t = advance(t, time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, c.timezone))
+			continue
+		}
+		if c.minutes&(1<<t.Minute()) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// advance returns the next time to check. When the next time is not after the current time, which can happen when the
+// clock is turned back at the end of daylight saving time, an hour is added to the current time instead.
+func advance(current time.Time, next time.Time) time.Time {
+	if !next.After(current) {
+		return current.Add(time.Hour)
+	}
+	return next
+}
+
+func (c *cronSchedule) matchesDay(t time.Time) bool {
+	dayOfMonth := c.daysOfMonth&(1<<t.Day()) != 0
+	dayOfWeek := c.daysOfWeek&(1<<int(t.Weekday())) != 0
+	switch {
+	case c.anyDayOfMonth && c.anyDayOfWeek:
+		return true
+	case c.anyDayOfMonth:
+		return dayOfWeek
+	case c.anyDayOfWeek:
+		return dayOfMonth
+	default:
+		return dayOfMonth || dayOfWeek
+	}
+}
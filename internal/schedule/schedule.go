@@ -0,0 +1,70 @@
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"overkiz-adapter/internal/config"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// maxSearchDays limits the search for the next time of a schedule, for example for the sunrise near the poles.
+const maxSearchDays = 366 * 5
+
+var astronomicalSpec = regexp.MustCompile(`^(sunrise|sunset|dawn|dusk)\s*(?:([+-])\s*(\S+))?$`)
+
+// Schedule calculates the times at which something should happen.
+type Schedule interface {
+	// Next returns the first time of the schedule after the given time, or the zero time when there is none.
+	Next(after time.Time) time.Time
+}
+
+// Parse parses the specification of a schedule. A specification is either a daily time like "07:30", a cron expression
+// with five fields like "30 7 * * 1-5", or an astronomical event with an optional offset like "sunset+15m". The
+// astronomical events sunrise, sunset, dawn and dusk are calculated for the coordinates of the location. All times are
+// in the timezone of the location, or in the local timezone when the location has no timezone.
+func Parse(spec string, location *config.Location) (Schedule, error) {
+	timezone, err := Timezone(location)
+	if err != nil {
+		return nil, err
+	}
+	spec = strings.TrimSpace(spec)
+	if matches := astronomicalSpec.FindStringSubmatch(spec); matches != nil {
+		if location == nil || (location.Latitude == 0 && location.Longitude == 0) {
+			return nil, fmt.Errorf("schedule %s requires a location with coordinates", spec)
+		}
+		var offset time.Duration
+		if matches[3] != "" {
+			offset, err = time.ParseDuration(matches[3])
+			if err != nil {
+				return nil, fmt.Errorf("invalid offset of schedule %s: %w", spec, err)
+			}
+			if matches[2] == "-" {
+				offset = -offset
+			}
+		}
+		return &sunSchedule{
+			event:     sunEvents[matches[1]],
+			latitude:  location.Latitude,
+			longitude: location.Longitude,
+			offset:    offset,
+			timezone:  timezone,
+		}, nil
+	}
+	if daily, err := time.Parse("15:04", spec); err == nil {
+		return parseCron(fmt.Sprintf("%d %d * * *", daily.Minute(), daily.Hour()), timezone)
+	}
+	if len(strings.Fields(spec)) == 5 {
+		return parseCron(spec, timezone)
+	}
+	return nil, errors.New("invalid schedule " + spec)
+}
+
+// Timezone returns the timezone of the location, or the local timezone when no timezone is configured.
+func Timezone(location *config.Location) (*time.Location, error) {
+	if location == nil || location.Timezone == "" {
+		return time.Local, nil
+	}
+	return time.LoadLocation(location.Timezone)
+}
@@ -0,0 +1,105 @@
+package schedule
+
+import (
+	"overkiz-adapter/internal/config"
+	"testing"
+	"time"
+)
+
+var amsterdam = &config.Location{Latitude: 52.37, Longitude: 4.90, Timezone: "Europe/Amsterdam"}
+
+func TestCron(t *testing.T) {
+	timezone, _ := time.LoadLocation(amsterdam.Timezone)
+	// Friday the 14th of June 2024.
+	after := time.Date(2024, 6, 14, 7, 30, 0, 0, timezone)
+	tests := []struct {
+		spec     string
+		expected time.Time
+	}{
+		{"07:45", time.Date(2024, 6, 14, 7, 45, 0, 0, timezone)},
+		{"07:30", time.Date(2024, 6, 15, 7, 30, 0, 0, timezone)},
+		{"*/20 * * * *", time.Date(2024, 6, 14, 7, 40, 0, 0, timezone)},
+		{"0 8-18/2 * * *", time.Date(2024, 6, 14, 8, 0, 0, 0, timezone)},
+		{"30 7 * * 1-5", time.Date(2024, 6, 17, 7, 30, 0, 0, timezone)},
+		{"0 9 * * 0", time.Date(2024, 6, 16, 9, 0, 0, 0, timezone)},
+		{"0 9 * * 7", time.Date(2024, 6, 16, 9, 0, 0, 0, timezone)},
+		{"0 0 1 1 *", time.Date(2025, 1, 1, 0, 0, 0, 0, timezone)},
+		{"0 12 29 2 *", time.Date(2028, 2, 29, 12, 0, 0, 0, timezone)},
+		// A day is matched by the day of month or the day of week when both are restricted, a field starting with *
+		// is unrestricted.
+		{"0 9 15 * 1", time.Date(2024, 6, 15, 9, 0, 0, 0, timezone)},
+		{"0 9 */2 * 1", time.Date(2024, 6, 17, 9, 0, 0, 0, timezone)},
+		{"0 9 1 * */2", time.Date(2024, 7, 1, 9, 0, 0, 0, timezone)},
+	}
+	for _, test := range tests {
+		s, err := Parse(test.spec, amsterdam)
+		if err != nil {
+			t.Errorf("Failed to parse %s: %v", test.spec, err)
+			continue
+		}
+		next := s.Next(after)
+		if !next.Equal(test.expected) {
+			t.Errorf("Expected %s to run at %s, got %s", test.spec, test.expected, next)
+		}
+	}
+}
+
+func TestSun(t *testing.T) {
+	timezone, _ := time.LoadLocation(amsterdam.Timezone)
+	after := time.Date(2024, 6, 21, 0, 0, 0, 0, timezone)
+	tests := []struct {
+		spec     string
+		expected time.Time
+	}{
+		{"sunrise", time.Date(2024, 6, 21, 5, 18, 0, 0, timezone)},
+		{"sunset", time.Date(2024, 6, 21, 22, 6, 0, 0, timezone)},
+		{"sunset+15m", time.Date(2024, 6, 21, 22, 21, 0, 0, timezone)},
+		{"sunrise - 1h", time.Date(2024, 6, 21, 4, 18, 0, 0, timezone)},
+		{"dusk", time.Date(2024, 6, 21, 22, 58, 0, 0, timezone)},
+	}
+	for _, test := range tests {
+		s, err := Parse(test.spec, amsterdam)
+		if err != nil {
+			t.Errorf("Failed to parse %s: %v", test.spec, err)
+			continue
+		}
+		next := s.Next(after)
+		if next.Sub(test.expected).Abs() > time.Minute*2 {
+			t.Errorf("Expected %s at %s, got %s", test.spec, test.expected, next)
+		}
+	}
+
+	// The sun does not set during the polar day.
+	s, _ := Parse("sunset", &config.Location{Latitude: 78.22, Longitude: 15.65, Timezone: "Arctic/Longyearbyen"})
+	next := s.Next(time.Date(2024, 6, 21, 0, 0, 0, 0, time.UTC))
+	if next.Month() != time.August {
+		t.Errorf("Expected the first sunset in August, got %s", next)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, spec := range []string{"sunset", "* * *", "60 * * * *", "* * * * 8", "5-1 * * * *", "sunset+15"} {
+		location := amsterdam
+		if spec == "sunset" {
+			location = nil
+		}
+		if _, err := Parse(spec, location); err == nil {
+			t.Errorf("Expected an error for %s", spec)
+		}
+	}
+}
+
+func TestTake(t *testing.T) {
+	j := &job{}
+	if !j.take() {
+		t.Error("Expected an active job to run")
+	}
+	j.skipNext = true
+	if j.take() || !j.take() {
+		t.Error("Expected only the next run to be skipped")
+	}
+	j.paused = true
+	if j.take() || j.take() {
+		t.Error("Expected a paused job not to run")
+	}
+}
@@ -0,0 +1,213 @@
+package schedule
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"overkiz-adapter/internal/actions"
+	"overkiz-adapter/internal/config"
+	"overkiz-adapter/internal/domain"
+	"overkiz-adapter/internal/log"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrUnknownSchedule = errors.New("unknown schedule")
+
+// Scheduler executes the actions of the configured schedules at their times.
+type Scheduler struct {
+	jobs     []*job
+	executor *actions.Executor
+	running  sync.WaitGroup
+}
+
+type job struct {
+	config   *config.Schedule
+	schedule Schedule
+	lock     sync.Mutex
+	paused   bool
+	skipNext bool
+	nextRun  time.Time
+	lastRun  time.Time
+	// lastError is the error of the last run, or empty when the last run succeeded.
+	lastError string
+}
+
+// Status is the state of a schedule as shown by the api.
+type Status struct {
+	Name      string     `json:"name"`
+	At        string     `json:"at"`
+	Paused    bool       `json:"paused"`
+	SkipNext  bool       `json:"skip_next"`
+	NextRun   *time.Time `json:"next_run,omitempty"`
+	LastRun   *time.Time `json:"last_run,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+}
+
+// NewScheduler creates a scheduler for the given schedules. An error is returned when the time of a schedule is invalid
+// or a schedule refers to a device or scene that does not exist.
+func NewScheduler(schedules []*config.Schedule, location *config.Location, gateways ...*domain.Overkiz) (*Scheduler, error) {
+	s := &Scheduler{
+		executor: actions.NewExecutor(gateways...),
+	}
+	var errs []error
+	for _, scheduleConfig := range schedules {
+		j, err := s.newJob(scheduleConfig, location)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("schedule %s: %w", scheduleConfig.Name, err))
+			continue
+		}
+		s.jobs = append(s.jobs, j)
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return s, nil
+}
+
+func (s *Scheduler) newJob(scheduleConfig *config.Schedule, location *config.Location) (*job, error) {
+	schedule, err := Parse(scheduleConfig.At, location)
+	if err != nil {
+		return nil, err
+	}
+	for _, action := range scheduleConfig.Actions {
+		err = s.executor.Validate(action)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &job{
+		config:   scheduleConfig,
+		schedule: schedule,
+		paused:   scheduleConfig.Paused,
+	}, nil
+}
+
+// Run executes the schedules until the context is done. A running execution is awaited before returning.
+func (s *Scheduler) Run(ctx context.Context) error {
+	for _, j := range s.jobs {
+		s.running.Add(1)
+		go s.run(ctx, j)
+	}
+	s.running.Wait()
+	return nil
+}
+
+func (s *Scheduler) run(ctx context.Context, j *job) {
+	defer s.running.Done()
+	for {
+		next := j.schedule.Next(time.Now())
+		j.setNextRun(next)
+		if next.IsZero() {
+			log.Warningf("Schedule %s has no next run", j.config.Name)
+			return
+		}
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if !j.take() {
+			log.Infof("Skipped schedule %s", j.config.Name)
+			continue
+		}
+		log.Infof("Executing schedule %s", j.config.Name)
+		err := s.executor.ExecuteAll(j.config.Actions)
+		if err != nil {
+			log.Errorf("Failed to execute schedule %s: %v", j.config.Name, err)
+		}
+		j.setLastRun(err)
+	}
+}
+
+// Schedules returns the status of all schedules.
+func (s *Scheduler) Schedules() []*Status {
+	result := make([]*Status, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		result = append(result, j.status())
+	}
+	return result
+}
+
+// Pause pauses or resumes the schedule with the given name. Resuming a schedule also cancels skipping its next run.
+func (s *Scheduler) Pause(name string, paused bool) (*Status, error) {
+	return s.update(name, func(j *job) {
+		j.paused = paused
+		if !paused {
+			j.skipNext = false
+		}
+	})
+}
+
+// SkipNext skips the next run of the schedule with the given name.
+func (s *Scheduler) SkipNext(name string) (*Status, error) {
+	return s.update(name, func(j *job) {
+		j.skipNext = true
+	})
+}
+
+func (s *Scheduler) update(name string, update func(j *job)) (*Status, error) {
+	for _, j := range s.jobs {
+		if strings.EqualFold(j.config.Name, name) {
+			j.lock.Lock()
+			update(j)
+			j.lock.Unlock()
+			return j.status(), nil
+		}
+	}
+	return nil, ErrUnknownSchedule
+}
+
+// take returns true when the job should be executed now. A skipped run is consumed.
+func (j *job) take() bool {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	if j.paused {
+		return false
+	}
+	if j.skipNext {
+		j.skipNext = false
+		return false
+	}
+	return true
+}
+
+func (j *job) setNextRun(next time.Time) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.nextRun = next
+}
+
+func (j *job) setLastRun(err error) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.lastRun = time.Now()
+	j.lastError = ""
+	if err != nil {
+		j.lastError = err.Error()
+	}
+}
+
+func (j *job) status() *Status {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	status := &Status{
+		Name:      j.config.Name,
+		At:        j.config.At,
+		Paused:    j.paused,
+		SkipNext:  j.skipNext,
+		LastError: j.lastError,
+	}
+	if !j.nextRun.IsZero() {
+		nextRun := j.nextRun
+		status.NextRun = &nextRun
+	}
+	if !j.lastRun.IsZero() {
+		lastRun := j.lastRun
+		status.LastRun = &lastRun
+	}
+	return status
+}
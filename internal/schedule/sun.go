@@ -0,0 +1,85 @@
+package schedule
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	julianUnixEpoch = 2440587.5
+	julianJ2000     = 2451545.0
+	earthObliquity  = 23.4397
+)
+
+// sunEvent is the moment the sun passes a certain elevation, either rising or setting.
+type sunEvent struct {
+	elevation float64
+	rising    bool
+}
+
+var sunEvents = map[string]*sunEvent{
+	// The sunrise and sunset are corrected for the refraction of the atmosphere and the size of the sun.
+	"sunrise": {elevation: -0.833, rising: true},
+	"sunset":  {elevation: -0.833},
+	// Dawn and dusk are the start and end of the civil twilight.
+	"dawn": {elevation: -6, rising: true},
+	"dusk": {elevation: -6},
+}
+
+// sunSchedule is a schedule that happens every day at an astronomical event, shifted by an offset.
+type sunSchedule struct {
+	event     *sunEvent
+	latitude  float64
+	longitude float64
+	offset    time.Duration
+	timezone  *time.Location
+}
+
+func (s *sunSchedule) Next(after time.Time) time.Time {
+	local := after.In(s.timezone)
+	// Start a day early, the offset can move the event of the previous day past the given time.
+	day := time.Date(local.Year(), local.Month(), local.Day()-1, 12, 0, 0, 0, s.timezone)
+	for i := 0; i < maxSearchDays; i++ {
+		event, ok := sunTime(s.event, day.AddDate(0, 0, i), s.latitude, s.longitude)
+		if !ok {
+			continue
+		}
+		next := event.Add(s.offset).Truncate(time.Second).In(s.timezone)
+		if next.After(after) {
+			return next
+		}
+	}
+	return time.Time{}
+}
+
+// sunTime calculates the time of the event at the date of the given day with the sunrise equation. False is returned
+// when the sun does not pass the elevation of the event on that day, like during the polar night.
+func sunTime(event *sunEvent, day time.Time, latitude float64, longitude float64) (time.Time, bool) {
+	date := time.Date(day.Year(), day.Month(), day.Day(), 12, 0, 0, 0, time.UTC)
+	n := math.Round(float64(date.Unix())/86400 + julianUnixEpoch - julianJ2000)
+	meanSolarTime := n - longitude/360
+	anomaly := math.Mod(357.5291+0.98560028*meanSolarTime, 360)
+	center := 1.9148*sin(anomaly) + 0.0200*sin(2*anomaly) + 0.0003*sin(3*anomaly)
+	eclipticLongitude := math.Mod(anomaly+center+180+102.9372, 360)
+	transit := julianJ2000 + meanSolarTime + 0.0053*sin(anomaly) - 0.0069*sin(2*eclipticLongitude)
+	declination := math.Asin(sin(eclipticLongitude) * sin(earthObliquity))
+	cosHourAngle := (sin(event.elevation) - sin(latitude)*math.Sin(declination)) / (cos(latitude) * math.Cos(declination))
+	if cosHourAngle < -1 || cosHourAngle > 1 {
+		return time.Time{}, false
+	}
+	hourAngle := math.Acos(cosHourAngle) * 180 / math.Pi
+	julian := transit + hourAngle/360
+	if event.rising {
+		julian = transit - hourAngle/360
+	}
+	seconds := (julian - julianUnixEpoch) * 86400
+	return time.Unix(int64(seconds), 0), true
+}
+
+func sin(degrees float64) float64 {
+	return math.Sin(degrees * math.Pi / 180)
+}
+
+func cos(degrees float64) float64 {
+	return math.Cos(degrees * math.Pi / 180)
+}
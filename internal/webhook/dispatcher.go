@@ -0,0 +1,293 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"overkiz-adapter/internal/config"
+	"overkiz-adapter/internal/domain"
+	"overkiz-adapter/internal/log"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const signatureHeader = "X-Overkiz-Signature"
+
+// Dispatcher calls the configured webhooks when their trigger matches an event of one of the gateways.
+type Dispatcher struct {
+	webhooks   []*webhook
+	gateways   []*domain.Overkiz
+	client     *http.Client
+	ctx        context.Context
+	lock       sync.Mutex
+	stopped    bool
+	deliveries sync.WaitGroup
+}
+
+type webhook struct {
+	config    *config.Webhook
+	condition *domain.StateCondition
+	body      *template.Template
+	// tracker detects the devices that start to match the state condition, so the webhook is only called once when a
+	// device starts to match.
+	tracker *domain.ConditionTracker
+}
+
+// payload is the data of a webhook call. It is sent as json body unless a body template is configured, in which case
+// it is the data of the template.
+type payload struct {
+	Webhook   string            `json:"webhook"`
+	Gateway   string            `json:"gateway"`
+	Event     string            `json:"event"`
+	Timestamp time.Time         `json:"timestamp"`
+	Device    *domain.Device    `json:"device,omitempty"`
+	Execution *domain.Execution `json:"execution,omitempty"`
+}
+
+var templateFunctions = template.FuncMap{
+	"json": func(value any) (string, error) {
+		data, err := json.Marshal(value)
+		return string(data), err
+	},
+	"state": func(device *domain.Device, name string) any {
+		if device == nil {
+			return nil
+		}
+		for _, state := range device.States {
+			if state.Name == name {
+				return state.Value
+			}
+		}
+		return nil
+	},
+}
+
+// NewDispatcher creates a dispatcher for the given webhooks. An error is returned when the body template of a webhook
+// is invalid.
+func NewDispatcher(webhooks []*config.Webhook, gateways ...*domain.Overkiz) (*Dispatcher, error) {
+	dispatcher := &Dispatcher{
+		gateways: gateways,
+		client:   &http.Client{},
+	}
+	for _, configuration := range webhooks {
+		w := &webhook{
+			config:    configuration,
+			condition: &configuration.Trigger.StateCondition,
+			tracker:   domain.NewConditionTracker(&configuration.Trigger.StateCondition),
+		}
+		if configuration.Body != "" {
+			body, err := template.New(configuration.Name).Funcs(templateFunctions).Parse(configuration.Body)
+			if err != nil {
+				return nil, fmt.Errorf("invalid body of webhook %s: %w", configuration.Name, err)
+			}
+			w.body = body
+		}
+		dispatcher.webhooks = append(dispatcher.webhooks, w)
+	}
+	return dispatcher, nil
+}
+
+// Run calls the webhooks on the events of the gateways until the context is done. Running calls are cancelled and
+// awaited before returning.
+func (d *Dispatcher) Run(ctx context.Context) error {
+	d.ctx = ctx
+	for _, gateway := range d.gateways {
+		overkiz := gateway
+		// Devices that already match when starting should not trigger the webhook on their next state change.
+		for _, device := range overkiz.Devices("") {
+			for _, w := range d.webhooks {
+				if w.handlesStates(overkiz) {
+					w.tracker.Update(overkiz, device)
+				}
+			}
+		}
+		overkiz.AddEventListener(func(event *domain.Event) {
+			d.handleEvent(overkiz, event)
+		})
+	}
+	<-ctx.Done()
+	d.lock.Lock()
+	d.stopped = true
+	d.lock.Unlock()
+	d.deliveries.Wait()
+	return nil
+}
+
+func (d *Dispatcher) handleEvent(overkiz *domain.Overkiz, event *domain.Event) {
+	for _, w := range d.webhooks {
+		switch event.Name {
+		case "DeviceStateChangedEvent":
+			if !w.handlesStates(overkiz) {
+				continue
+			}
+			device := overkiz.Device(event.DeviceURL)
+			if device == nil || !w.tracker.Update(overkiz, device) {
+				continue
+			}
+			d.deliver(w, newPayload(w, overkiz, event, device, nil))
+		case "ExecutionStateChangedEvent":
+			if !w.handlesExecutions(overkiz) || event.NewState != w.config.Trigger.Execution {
+				continue
+			}
+			execution := overkiz.TrackedExecution(event.ExecId)
+			if !w.matchesExecution(overkiz, execution) {
+				continue
+			}
+			if execution == nil {
+				execution = &domain.Execution{
+					Id:             event.ExecId,
+					State:          event.NewState,
+					FailureType:    event.FailureType,
+					FailedCommands: event.FailedCommands,
+				}
+			}
+			d.deliver(w, newPayload(w, overkiz, event, nil, execution))
+		}
+	}
+}
+
+func newPayload(w *webhook, overkiz *domain.Overkiz, event *domain.Event, device *domain.Device, execution *domain.Execution) *payload {
+	timestamp := time.Now()
+	if event.Timestamp > 0 {
+		timestamp = time.UnixMilli(event.Timestamp)
+	}
+	return &payload{
+		Webhook:   w.config.Name,
+		Gateway:   overkiz.Name(),
+		Event:     event.Name,
+		Timestamp: timestamp,
+		Device:    device,
+		Execution: execution,
+	}
+}
+
+func (w *webhook) matchesGateway(overkiz *domain.Overkiz) bool {
+	return w.config.Gateway == "" || w.config.Gateway == overkiz.Name()
+}
+
+func (w *webhook) handlesStates(overkiz *domain.Overkiz) bool {
+	return w.config.Trigger.Execution == "" && w.matchesGateway(overkiz)
+}
+
+func (w *webhook) handlesExecutions(overkiz *domain.Overkiz) bool {
+	return w.config.Trigger.Execution != "" && w.matchesGateway(overkiz)
+}
+
+// matchesExecution returns true when the execution contains a device that matches the trigger. Without a device or
+// class in the trigger all executions match, including executions that were not started by the adapter.
+func (w *webhook) matchesExecution(overkiz *domain.Overkiz, execution *domain.Execution) bool {
+	condition := w.condition
+	if condition.Device == "" && condition.Class == "" {
+		return true
+	}
+	if execution == nil {
+		return false
+	}
+	for _, deviceURL := range execution.DeviceURLs {
+		device := overkiz.Device(deviceURL)
+		if device != nil && domain.MatchesDevice(condition, device) {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *webhook) render(data *payload) ([]byte, error) {
+	if w.body == nil {
+		return json.Marshal(data)
+	}
+	var body bytes.Buffer
+	err := w.body.Execute(&body, data)
+	if err != nil {
+		return nil, err
+	}
+	return body.Bytes(), nil
+}
+
+// deliver calls the webhook in the background, unless the dispatcher is stopped.
+func (d *Dispatcher) deliver(w *webhook, data *payload) {
+	body, err := w.render(data)
+	if err != nil {
+		log.Errorf("Failed to render body of webhook %s: %v", w.config.Name, err)
+		return
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.stopped {
+		return
+	}
+	d.deliveries.Add(1)
+	go func() {
+		defer d.deliveries.Done()
+		d.send(d.ctx, w, body)
+	}()
+}
+
+// send posts the body to the webhook. Failed calls are retried with an increasing interval, unless the receiver
+// rejects the call.
+func (d *Dispatcher) send(ctx context.Context, w *webhook, body []byte) {
+	interval := w.config.RetryInterval.Duration
+	for attempt := 0; ; attempt++ {
+		retry, err := d.post(ctx, w, body)
+		if err == nil {
+			log.Debugf("Called webhook %s", w.config.Name)
+			return
+		}
+		if !retry || attempt >= *w.config.Retries || ctx.Err() != nil {
+			log.Errorf("Failed to call webhook %s: %v", w.config.Name, err)
+			return
+		}
+		log.Warningf("Failed to call webhook %s, retrying in %s: %v", w.config.Name, interval, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+		interval *= 2
+	}
+}
+
+// post does a single call of the webhook. Besides the error it returns whether the call can be retried.
+func (d *Dispatcher) post(ctx context.Context, w *webhook, body []byte) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, w.config.Timeout.Duration)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, "POST", w.config.Url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "overkiz-adapter")
+	for name, value := range w.config.Headers {
+		request.Header.Set(name, value)
+	}
+	if w.config.Secret != "" {
+		request.Header.Set(signatureHeader, sign(w.config.Secret, body))
+	}
+	response, err := d.client.Do(request)
+	if err != nil {
+		return true, err
+	}
+	defer func(body io.ReadCloser) {
+		_ = body.Close()
+	}(response.Body)
+	_, _ = io.Copy(io.Discard, response.Body)
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return false, nil
+	}
+	retry := response.StatusCode >= 500 || response.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("%s", response.Status)
+}
+
+// sign returns the hex encoded HMAC-SHA256 of the body, prefixed with the algorithm.
+func sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,128 @@
+package webhook
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"overkiz-adapter/internal/config"
+	"overkiz-adapter/internal/domain"
+	"overkiz-adapter/internal/domain/domaintest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type call struct {
+	body      string
+	signature string
+}
+
+func TestDispatcher(t *testing.T) {
+	calls := make(chan *call, 10)
+	receiver := newReceiver(t, calls, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// The events are fetched after the dispatcher is running.
+	gateway := domaintest.NewGateway(t, `[{"label":"Bedroom","deviceURL":"io://1234-5678-9012/1","definition":{"uiClass":"RollerShutter"},"states":[{"name":"core:ClosureState","type":1,"value":0}]}]`,
+		`[]`, `[{"name":"DeviceStateChangedEvent","deviceURL":"io://1234-5678-9012/1","deviceStates":[{"name":"core:ClosureState","type":1,"value":"100"}]},`+
+			`{"name":"ExecutionStateChangedEvent","execId":"1","newState":"FAILED","oldState":"IN_PROGRESS","failureType":"CMDCANCELLED"}]`)
+	overkiz, err := domain.NewOverkiz(gateway.Settings(), ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	retries := 0
+	closed := float64(100)
+	dispatcher, err := NewDispatcher([]*config.Webhook{
+		{
+			Name:    "closed",
+			Url:     receiver.URL,
+			Secret:  "secret",
+			Body:    `{"text":{{json .Device.Label}},"closure":{{state .Device "core:ClosureState"}}}`,
+			Trigger: &config.WebhookTrigger{StateCondition: domain.StateCondition{Class: "RollerShutter", State: "core:ClosureState", Value: closed}},
+			Timeout: config.Duration{Duration: time.Second},
+			Retries: &retries,
+		},
+		{
+			Name:    "failed",
+			Url:     receiver.URL,
+			Trigger: &config.WebhookTrigger{Execution: domain.ExecutionFailed},
+			Timeout: config.Duration{Duration: time.Second},
+			Retries: &retries,
+		},
+	}, overkiz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		_ = dispatcher.Run(ctx)
+		close(done)
+	}()
+
+	expected := map[string]string{
+		`{"text":"Bedroom","closure":100}`: sign("secret", []byte(`{"text":"Bedroom","closure":100}`)),
+		`"webhook":"failed"`:               "",
+	}
+	for len(expected) > 0 {
+		select {
+		case c := <-calls:
+			found := false
+			for content, signature := range expected {
+				if strings.Contains(c.body, content) {
+					if c.signature != signature {
+						t.Errorf("Unexpected signature %s of body %s", c.signature, c.body)
+					}
+					delete(expected, content)
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("Unexpected call %s", c.body)
+			}
+		case <-time.After(time.Second * 10):
+			t.Fatalf("Webhooks not called with %v", expected)
+		}
+	}
+
+	cancel()
+	<-done
+}
+
+func TestRetry(t *testing.T) {
+	calls := make(chan *call, 10)
+	receiver := newReceiver(t, calls, 2)
+	retries := 2
+	dispatcher, err := NewDispatcher(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := &webhook{config: &config.Webhook{
+		Name:          "retry",
+		Url:           receiver.URL,
+		Timeout:       config.Duration{Duration: time.Second},
+		Retries:       &retries,
+		RetryInterval: config.Duration{Duration: time.Millisecond},
+	}}
+	dispatcher.send(context.Background(), w, []byte(`{}`))
+	if len(calls) != 3 {
+		t.Errorf("Expected 3 calls, got %d", len(calls))
+	}
+}
+
+// newReceiver starts a webhook receiver that writes the received calls to the given channel. The first calls fail with
+// an internal server error.
+func newReceiver(t *testing.T, calls chan<- *call, failures int32) *httptest.Server {
+	var count atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		calls <- &call{body: string(body), signature: r.Header.Get(signatureHeader)}
+		if count.Add(1) <= failures {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	t.Cleanup(receiver.Close)
+	return receiver
+}